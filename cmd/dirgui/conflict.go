@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxDiffLines is the most lines a file can have for the diff view to compare it line by line.
+const maxDiffLines = 1000
+
+// A fileVersion identifies what was in a file when it was loaded or saved.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// A conflict is a change on disk to a field's file since it was loaded or saved, found when saving it.
+type conflict struct {
+	disk        string // what's in the file now
+	exists      bool
+	modTime     time.Time
+	loaded      bool // whether the field had been loaded, or else was edited without loading it
+	showDiff    bool
+	diffView    EditorState
+	diff        string // between disk and diffedField
+	diffedField string
+}
+
+// readVersion reads the file at path, returning its contents and version.
+func readVersion(path string) ([]byte, fileVersion, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fileVersion{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileVersion{}, err
+	}
+	return content, fileVersion{info.ModTime(), int64(len(content)), sha256.Sum256(content)}, nil
+}
+
+// checkDisk reads the file at path before fileContent is saved to it, failing with a conflict if it changed since version (as of the field's last Load or Save, or nil if neither has happened), unless it already holds fileContent. It returns what's in the file, and whether it exists.
+func checkDisk(path, fileContent string, version *fileVersion) (disk []byte, exists bool, c *conflict, err error) {
+	disk, current, err := readVersion(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, nil, fmt.Errorf("couldn't read %q: %v", path, err)
+	}
+	exists = err == nil
+
+	unchanged := len(disk) == 0 // nothing to lose
+	if version != nil {
+		unchanged = exists && current.size == version.size && current.hash == version.hash
+	}
+	if !unchanged && string(disk) != fileContent {
+		c := &conflict{disk: string(disk), exists: exists, modTime: current.modTime}
+		return disk, exists, c, fmt.Errorf("%q changed on disk since it was loaded", path)
+	}
+	return disk, exists, nil, nil
+}
+
+// savedVersion returns the version of the file at path, which fileContent was just written to.
+func savedVersion(path, fileContent string) *fileVersion {
+	version := &fileVersion{size: int64(len(fileContent)), hash: sha256.Sum256([]byte(fileContent))}
+	if info, err := os.Stat(path); err == nil {
+		version.modTime = info.ModTime()
+	}
+	return version
+}
+
+// saved records that content was just saved to the field's file, which is now at version. formsLock must be held.
+func (widget *Widget) saved(content string, version *fileVersion) {
+	widget.version = version
+	widget.loaded = true
+	widget.diskContent = content
+	widget.conflict = nil
+}
+
+// startSave writes the field's value to its file in the background, unless the file changed since it was loaded or saved and overwrite is false. formsLock must be held, but it isn't while the file is read and written.
+func (widget *Widget) startSave(overwrite bool) {
+	widget.saving = true
+	path, content, fileContent, since := widget.path(), widget.content, widget.fileContent(), widget.version
+	go func(widget *Widget) {
+		version, c, err := saveFile(path, fileContent, since, overwrite)
+		formsLock.Lock()
+		defer formsLock.Unlock()
+		widget.saving = false
+		if c != nil {
+			c.loaded = widget.loaded
+			widget.conflict = c
+		}
+		if err != nil {
+			log.Printf("couldn't save: %v", err)
+			return
+		}
+		widget.saved(content, version)
+	}(widget)
+}
+
+// saveFile writes fileContent to the file at path, returning its new version, unless the file changed since version and overwrite is false, in which case it returns the conflict.
+func saveFile(path, fileContent string, version *fileVersion, overwrite bool) (*fileVersion, *conflict, error) {
+	if !overwrite {
+		if _, _, c, err := checkDisk(path, fileContent, version); err != nil {
+			return nil, c, err
+		}
+	}
+	if _, err := writeFile(path, []byte(fileContent), true); err != nil {
+		return nil, nil, fmt.Errorf("couldn't write %q: %v", path, err)
+	}
+	return savedVersion(path, fileContent), nil, nil
+}
+
+// conflictPrompt explains the field's conflict, if it has one, starting at y, with buttons to overwrite the file, reload it, or compare it to the field. It returns the bottom of what it drew.
+func conflictPrompt(s *session, widget *Widget, y int, img draw.Image, keyEvent *rfb.KeyEvent) int {
+	c := widget.conflict
+	if c == nil {
+		return y
+	}
+	face := s.face()
+	buttonHeight := lineHeight + 8
+
+	var problem string
+	switch {
+	case !c.exists:
+		problem = "The file was deleted since it was loaded."
+	case !c.loaded:
+		problem = "The file has contents that haven't been loaded."
+	default:
+		problem = fmt.Sprintf("The file changed on disk at %s, after it was loaded.", c.modTime.Format("15:04:05"))
+	}
+	y = paragraph(s, problem, failureColor, y+4, img) + 4
+
+	x := 8
+	rect := image.Rect(x, y, x+buttonWidth(face, "Overwrite"), y+buttonHeight)
+	if button(s, &widget.overwriteButton, "Overwrite", rect, img, keyEvent) && !widget.saving && !widget.loading {
+		widget.startSave(true)
+	}
+	x = rect.Max.X + 8
+
+	rect = image.Rect(x, y, x+buttonWidth(face, "Reload"), y+buttonHeight)
+	if button(s, &widget.reloadButton, "Reload", rect, img, keyEvent) && !widget.saving && !widget.loading {
+		widget.stale = false
+		widget.load()
+	}
+	x = rect.Max.X + 8
+
+	toggle := "Diff"
+	if c.showDiff {
+		toggle = "Hide diff"
+	}
+	rect = image.Rect(x, y, x+buttonWidth(face, "Hide diff"), y+buttonHeight)
+	if button(s, &widget.diffButton, toggle, rect, img, keyEvent) {
+		c.showDiff = !c.showDiff
+		c.diffView = EditorState{readOnly: true}
+	}
+	y += buttonHeight
+
+	if c.showDiff {
+		if field := widget.fileContent(); c.diff == "" || field != c.diffedField {
+			c.diff = diffLines(c.disk, field)
+			c.diffedField = field
+		}
+		rect := image.Rect(8, y+8, windowWidth-8, y+8+textAreaRows*lineHeight+8)
+		textArea(s, &c.diffView, &c.diff, rect, img, keyEvent)
+		y = rect.Max.Y
+	}
+	return y
+}
+
+// diffLines compares the lines of a file on disk with the lines in a field, marking those only on disk with "-" and those only in the field with "+".
+func diffLines(disk, field string) string {
+	a, b := splitLines(disk), splitLines(field)
+	var out strings.Builder
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			out.WriteString("- " + line + "\n")
+		}
+		for _, line := range b {
+			out.WriteString("+ " + line + "\n")
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"sync"
+)
+
+var scrollback = flag.Int("scrollback", 1000, "Number of lines of each run's output to keep")
+
+// console collects a program's output for display, stripping ANSI escape sequences and keeping only the last *scrollback lines. It's safe to write to while it's being read.
+type console struct {
+	lock  sync.Mutex
+	text  []byte
+	lines int // newlines in text
+
+	escape    []byte // unfinished escape sequence
+	returning bool   // a carriage return was just written, so the line will be overwritten
+}
+
+func (c *console) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, b := range p {
+		if c.escape != nil {
+			c.escape = append(c.escape, b)
+			if escapeDone(c.escape) {
+				c.escape = nil
+			}
+			continue
+		}
+
+		switch b {
+		case 0x1b: // ESC
+			c.escape = []byte{b}
+		case '\r':
+			c.returning = true
+		case '\b':
+			if len(c.text) > 0 && c.text[len(c.text)-1] != '\n' {
+				c.text = c.text[:len(c.text)-1]
+			}
+		case '\n':
+			c.returning = false
+			c.text = append(c.text, b)
+			c.lines++
+		default:
+			if c.returning {
+				c.text = c.text[:bytes.LastIndexByte(c.text, '\n')+1]
+				c.returning = false
+			}
+			c.text = append(c.text, b)
+		}
+	}
+
+	for c.lines > *scrollback {
+		c.text = c.text[bytes.IndexByte(c.text, '\n')+1:]
+		c.lines--
+	}
+	return len(p), nil
+}
+
+// escapeDone reports whether seq, which starts with ESC, is a complete escape sequence.
+func escapeDone(seq []byte) bool {
+	if len(seq) < 2 {
+		return false
+	}
+	last := seq[len(seq)-1]
+	switch seq[1] {
+	case '[': // CSI, like colors: ESC [ parameters final
+		return len(seq) > 2 && last >= 0x40 && last <= 0x7e
+	case ']': // OSC, like window titles: ESC ] text BEL or ESC ] text ESC \
+		return last == 0x07 || (len(seq) > 3 && last == '\\' && seq[len(seq)-2] == 0x1b)
+	default: // two-character sequence
+		return true
+	}
+}
+
+func (c *console) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return string(c.text)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Suffixes appended to a file's name to name the files that configure its field: the choices for a dropdown, one per line, and the range of a slider, like "0 100" or "0 1 0.1" with a step.
+const (
+	optionsSuffix = ".options"
+	rangeSuffix   = ".range"
+)
+
+// maxValueSize is the size of the largest file whose contents are checked for a value like "true" or "42".
+const maxValueSize = 64
+
+// A fieldKind is how a field's value is edited.
+type fieldKind int
+
+const (
+	textKind     fieldKind = iota // in a text field or text area
+	checkboxKind                  // by checking a box, for true/false or 1/0
+	numberKind                    // in a spin box
+	sliderKind                    // by sliding a thumb between the ends of the range
+	dropdownKind                  // by choosing one of the options
+)
+
+// fieldKinds are the kinds of field that can be chosen in a manifest.
+var fieldKinds = map[string]fieldKind{
+	"field":    textKind,
+	"textarea": textKind,
+	"checkbox": checkboxKind,
+	"number":   numberKind,
+	"slider":   sliderKind,
+	"dropdown": dropdownKind,
+}
+
+var numberPattern = regexp.MustCompile(`^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)$`)
+
+// toggled maps each checkbox value to its opposite.
+var toggled = map[string]string{"true": "false", "false": "true", "1": "0", "0": "1"}
+
+// A fieldConfig is how a field is edited, as read by readFieldConfig.
+type fieldConfig struct {
+	kind           fieldKind
+	options        []string
+	min, max, step float64
+	hasRange       bool
+	validator      string
+	value          []byte // what's in the file, if it was read to choose the kind
+	version        fileVersion
+}
+
+// readFieldConfig decides how the field for the file at path, described by info and settings, is edited: as the manifest says, or else with a dropdown if it has options, or else by what's in its file. It reads the files it needs to without touching the field's widget, so formsLock needn't be held.
+func readFieldConfig(path string, info os.FileInfo, settings fileSettings, multiline bool) fieldConfig {
+	var c fieldConfig
+	c.options = settings.Options
+	if c.options == nil {
+		c.options = readOptions(path + optionsSuffix)
+	}
+	c.min, c.max, c.step, c.hasRange = readRange(path + rangeSuffix)
+	if settings.Min != nil && settings.Max != nil {
+		c.min, c.max, c.hasRange = *settings.Min, *settings.Max, true
+	}
+	if settings.Step != 0 {
+		c.step = settings.Step
+	}
+
+	kind, forced := fieldKinds[settings.Type]
+	if kind != textKind || (!forced && !multiline && info.Size() <= maxValueSize) {
+		var err error
+		if c.value, c.version, err = readVersion(path); err != nil {
+			log.Printf("couldn't read %q: %v", path, err)
+		}
+	}
+	if !forced {
+		if c.options != nil {
+			kind = dropdownKind
+		} else if !multiline {
+			kind = inferKind(strings.TrimSpace(string(c.value)), c.hasRange)
+		}
+	}
+	c.kind = kind
+	c.validator = findValidator(path, settings)
+	return c
+}
+
+// configure sets up widget's field as c says. Fields that aren't edited as text are loaded right away, since their values are what they show.
+func (widget *Widget) configure(c fieldConfig) {
+	widget.kind = c.kind
+	widget.options = c.options
+	widget.min, widget.max, widget.step, widget.hasRange = c.min, c.max, c.step, c.hasRange
+	widget.validator = c.validator
+
+	if c.kind != textKind && c.value != nil && !widget.loaded && !widget.loading {
+		widget.setText(string(c.value))
+		widget.diskContent = widget.content
+		version := c.version
+		widget.version = &version
+		widget.loaded = true
+	}
+}
+
+// inferKind returns the kind of field suited to value, given whether the field has a range.
+func inferKind(value string, hasRange bool) fieldKind {
+	switch {
+	case hasRange && numberPattern.MatchString(value):
+		return sliderKind
+	case toggled[value] != "":
+		return checkboxKind
+	case numberPattern.MatchString(value):
+		return numberKind
+	}
+	return textKind
+}
+
+// readOptions returns the non-blank lines of the options file at path, or nil if there isn't one.
+func readOptions(path string) []string {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Printf("couldn't read options: %v", err)
+		return nil
+	}
+	options := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			options = append(options, line)
+		}
+	}
+	return options
+}
+
+// readRange parses the range file at path, reporting whether there was a valid one.
+func readRange(path string) (min, max, step float64, ok bool) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, 0, 0, false
+	} else if err != nil {
+		log.Printf("couldn't read range: %v", err)
+		return 0, 0, 0, false
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 && len(fields) != 3 {
+		log.Printf("couldn't parse range in %q: expected \"min max\" or \"min max step\"", path)
+		return 0, 0, 0, false
+	}
+	var values [3]float64
+	for i, field := range fields {
+		if values[i], err = strconv.ParseFloat(field, 64); err != nil {
+			log.Printf("couldn't parse range in %q: %v", path, err)
+			return 0, 0, 0, false
+		}
+	}
+	if values[0] >= values[1] {
+		log.Printf("couldn't parse range in %q: min must be less than max", path)
+		return 0, 0, 0, false
+	}
+	return values[0], values[1], values[2], true
+}
+
+// stepSize is how much the field's value changes with each step.
+func (widget *Widget) stepSize() float64 {
+	if widget.step > 0 {
+		return widget.step
+	}
+	if widget.hasRange && widget.max-widget.min <= 1 {
+		return (widget.max - widget.min) / 100
+	}
+	return 1
+}
+
+// number returns the field's value as a number, or the bottom of its range (or 0) if it isn't one.
+func (widget *Widget) number() float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(widget.content), 64)
+	if err != nil {
+		return widget.min
+	}
+	return v
+}
+
+// setNumber sets the field's value to v, clamped to its range and written with as many decimal places as its value or step have.
+func (widget *Widget) setNumber(v float64) {
+	if widget.hasRange {
+		v = math.Max(widget.min, math.Min(widget.max, v))
+	}
+	places := decimalPlaces(strings.TrimSpace(widget.content))
+	if p := decimalPlaces(strconv.FormatFloat(widget.stepSize(), 'f', -1, 64)); p > places {
+		places = p
+	}
+	widget.content = strconv.FormatFloat(v, 'f', places, 64)
+}
+
+func decimalPlaces(number string) int {
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		return len(number) - i - 1
+	}
+	return 0
+}
+
+// checkbox draws a box in rect that toggles the field's value when it's clicked, followed by the value.
+func checkbox(s *session, widget *Widget, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	value := strings.TrimSpace(widget.content)
+	size := lineHeight
+	box := image.Rect(rect.Min.X, rect.Min.Y+(rect.Dy()-size)/2, rect.Min.X+size, rect.Min.Y+(rect.Dy()+size)/2)
+	textRect := image.Rect(box.Max.X+8, rect.Min.Y+(rect.Dy()-lineHeight)/2, rect.Max.X, rect.Min.Y+(rect.Dy()+lineHeight)/2)
+	width := label(s, value, textRect, img)
+
+	focused, _, clicked := click(s, &widget.button3, image.Rect(box.Min.X, box.Min.Y, textRect.Min.X+width, box.Max.Y), keyEvent)
+	if clicked {
+		if t, ok := toggled[value]; ok {
+			widget.content = t
+		} else {
+			widget.content = "true"
+		}
+	}
+
+	draw.Draw(img, box, image.NewUniform(color.Black), image.ZP, draw.Src)
+	if value == "true" || value == "1" {
+		draw.Draw(img, box.Inset(1), image.NewUniform(primaryColor), image.ZP, draw.Src)
+		check(box.Inset(4), img)
+	} else {
+		draw.Draw(img, box.Inset(1), image.NewUniform(color.White), image.ZP, draw.Src)
+	}
+	if focused {
+		focusRing(box, img)
+	}
+}
+
+// check draws a check mark in rect.
+func check(rect image.Rectangle, img draw.Image) {
+	white := image.NewUniform(color.White)
+	knee := image.Pt(rect.Min.X+rect.Dx()/3, rect.Max.Y-2)
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		var y int
+		if x < knee.X {
+			y = knee.Y - (knee.X-x)*(knee.Y-rect.Min.Y-rect.Dy()/2)/(knee.X-rect.Min.X)
+		} else {
+			y = knee.Y - (x-knee.X)*(knee.Y-rect.Min.Y)/(rect.Max.X-knee.X)
+		}
+		draw.Draw(img, image.Rect(x, y-1, x+1, y+1), white, image.ZP, draw.Src)
+	}
+}
+
+// spinBox draws a text field in rect for a number, with buttons (and the Up and Down keys) for stepping it.
+func spinBox(s *session, widget *Widget, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	if s.focus == &widget.editor && keyEvent != nil && keyEvent.Pressed {
+		switch keyEvent.KeySym {
+		case 0xff52, 0xff97: // Up, KP_Up
+			widget.setNumber(widget.number() + widget.stepSize())
+			keyEvent = nil
+		case 0xff54, 0xff99: // Down, KP_Down
+			widget.setNumber(widget.number() - widget.stepSize())
+			keyEvent = nil
+		}
+	}
+
+	stepWidth := buttonWidth(s.face(), "+")
+	decRect := image.Rect(rect.Max.X-2*stepWidth-4, rect.Min.Y, rect.Max.X-stepWidth-4, rect.Max.Y)
+	incRect := image.Rect(rect.Max.X-stepWidth, rect.Min.Y, rect.Max.X, rect.Max.Y)
+	edit(s, &widget.editor, &widget.content, image.Rect(rect.Min.X, rect.Min.Y, decRect.Min.X-4, rect.Max.Y), img, keyEvent)
+	if button(s, &widget.button3, "-", decRect, img, keyEvent) {
+		widget.setNumber(widget.number() - widget.stepSize())
+	}
+	if button(s, &widget.button4, "+", incRect, img, keyEvent) {
+		widget.setNumber(widget.number() + widget.stepSize())
+	}
+}
+
+// slider draws a track in rect with a thumb for the number in the field that can be dragged, or moved with the arrow keys, Home, and End, followed by the number.
+func slider(s *session, widget *Widget, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	face := s.face()
+	valueWidth := textWidth(face, strconv.FormatFloat(widget.max, 'f', -1, 64)) + textWidth(face, ".00")
+	track := image.Rect(rect.Min.X+6, rect.Min.Y, rect.Max.X-valueWidth-14, rect.Max.Y)
+
+	focused, hovering, _ := click(s, &widget.button3, rect, keyEvent)
+	if hovering {
+		s.cursor = arrowCursor // the hand is for buttons
+	}
+	if step := widget.stepSize(); widget.button3.clicking && track.Dx() > 0 && step > 0 {
+		fraction := float64(int(s.pointerEvent.X)-track.Min.X) / float64(track.Dx())
+		widget.setNumber(widget.min + math.Round(fraction*(widget.max-widget.min)/step)*step)
+	}
+	if focused && keyEvent != nil && keyEvent.Pressed {
+		switch keyEvent.KeySym {
+		case 0xff51, 0xff96, 0xff54, 0xff99: // Left, KP_Left, Down, KP_Down
+			widget.setNumber(widget.number() - widget.stepSize())
+		case 0xff53, 0xff98, 0xff52, 0xff97: // Right, KP_Right, Up, KP_Up
+			widget.setNumber(widget.number() + widget.stepSize())
+		case 0xff50, 0xff95: // Home, KP_Home
+			widget.setNumber(widget.min)
+		case 0xff57, 0xff9c: // End, KP_End
+			widget.setNumber(widget.max)
+		}
+	}
+
+	fraction := 0.0
+	if widget.max > widget.min {
+		fraction = math.Max(0, math.Min(1, (widget.number()-widget.min)/(widget.max-widget.min)))
+	}
+	thumbX := track.Min.X + int(fraction*float64(track.Dx()))
+	middle := rect.Min.Y + rect.Dy()/2
+	draw.Draw(img, image.Rect(track.Min.X, middle-2, thumbX, middle+2), image.NewUniform(primaryColor), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(thumbX, middle-2, track.Max.X, middle+2), image.NewUniform(disabledColor), image.ZP, draw.Src)
+	thumb := image.Rect(thumbX-6, rect.Min.Y+4, thumbX+6, rect.Max.Y-4)
+	c := image.NewUniform(primaryColor)
+	if widget.button3.clicking {
+		c.C = color.Black
+	}
+	draw.Draw(img, thumb, c, image.ZP, draw.Src)
+	if focused {
+		focusRing(thumb, img)
+	}
+
+	textTop := rect.Min.Y + (rect.Dy()-lineHeight)/2
+	label(s, strings.TrimSpace(widget.content), image.Rect(track.Max.X+14, textTop, rect.Max.X, textTop+lineHeight), img)
+}
+
+// dropdown draws a box in rect showing the field's value that opens the list of options (see optionList) when it's clicked. The Up and Down keys choose the previous or next option.
+func dropdown(s *session, widget *Widget, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	focused, _, clicked := click(s, &widget.button3, rect, keyEvent)
+	if clicked {
+		widget.showOptions = !widget.showOptions
+	}
+	if focused && keyEvent != nil && keyEvent.Pressed && len(widget.options) > 0 {
+		current := -1
+		for i, option := range widget.options {
+			if option == widget.content {
+				current = i
+			}
+		}
+		switch keyEvent.KeySym {
+		case 0xff52, 0xff97: // Up, KP_Up
+			if current > 0 {
+				widget.content = widget.options[current-1]
+			}
+		case 0xff54, 0xff99: // Down, KP_Down
+			if current < len(widget.options)-1 {
+				widget.content = widget.options[current+1]
+			}
+		case 0xff1b: // Escape
+			widget.showOptions = false
+		}
+	}
+
+	draw.Draw(img, rect, image.NewUniform(color.Black), image.ZP, draw.Src)
+	draw.Draw(img, rect.Inset(1), image.NewUniform(color.White), image.ZP, draw.Src)
+	textTop := rect.Min.Y + (rect.Dy()-lineHeight)/2
+	label(s, widget.content, image.Rect(rect.Min.X+8, textTop, rect.Max.X-24, textTop+lineHeight), img)
+
+	// arrow
+	middle := image.Pt(rect.Max.X-14, rect.Min.Y+rect.Dy()/2)
+	for i := 0; i < 4; i++ {
+		y := middle.Y - 2 + i
+		if widget.showOptions {
+			y = middle.Y + 1 - i
+		}
+		draw.Draw(img, image.Rect(middle.X-4+i, y, middle.X+4-i, y+1), image.NewUniform(color.Black), image.ZP, draw.Src)
+	}
+	if focused {
+		focusRing(rect, img)
+	}
+}
+
+// optionList draws the options for widget's dropdown starting at y, if it's open, and returns the bottom of what it drew. Clicking an option chooses it and closes the list.
+func optionList(s *session, widget *Widget, y int, img draw.Image, keyEvent *rfb.KeyEvent) int {
+	if !widget.showOptions {
+		return y
+	}
+	for len(widget.optionButtons) < len(widget.options) {
+		widget.optionButtons = append(widget.optionButtons, ButtonState{})
+	}
+
+	height := lineHeight + 4
+	y += 4
+	for i, option := range widget.options {
+		rect := image.Rect(16, y, windowWidth-8, y+height)
+		if option == widget.content {
+			draw.Draw(img, rect, image.NewUniform(selectionColor), image.ZP, draw.Src)
+		}
+		if link(s, &widget.optionButtons[i], option, rect.Inset(2), img, keyEvent) {
+			widget.content = option
+			widget.showOptions = false
+		}
+		y += height
+	}
+	return y
+}
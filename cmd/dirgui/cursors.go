@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/color"
+)
+
+var arrowCursor = asciiCursor(image.Pt(0, 0),
+	"X",
+	"XX",
+	"X.X",
+	"X..X",
+	"X...X",
+	"X....X",
+	"X.....X",
+	"X......X",
+	"X.......X",
+	"X........X",
+	"X.....XXXXX",
+	"X..X..X",
+	"X.X X..X",
+	"XX  X..X",
+	"X    X..X",
+	"     X..X",
+	"      XX",
+)
+
+var ibeamCursor = asciiCursor(image.Pt(3, 8),
+	"XXX XXX",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"   X",
+	"XXX XXX",
+)
+
+var handCursor = asciiCursor(image.Pt(5, 0),
+	"     XX",
+	"    X..X",
+	"    X..X",
+	"    X..X",
+	"    X..XXX",
+	"    X..X..XXX",
+	"    X..X..X..XX",
+	" XX X..X..X..X.X",
+	"X..XX........X.X",
+	"X...X..........X",
+	" X.............X",
+	"  X............X",
+	"  X...........X",
+	"   X..........X",
+	"    X........X",
+	"     X.......X",
+	"     XXXXXXXXX",
+)
+
+// asciiCursor makes a cursor from rows of text, where 'X' is black, '.' is white, and anything else is transparent.
+func asciiCursor(hotspot image.Point, rows ...string) *rfb.Cursor {
+	var width int
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			switch c {
+			case 'X':
+				img.Set(x, y, color.Black)
+			case '.':
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return &rfb.Cursor{Image: img, Hotspot: hotspot}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "os"
+
+// preserveOwner does nothing on Windows, where files don't have Unix owners.
+func preserveOwner(path string, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing on Windows, where directories can't be synced.
+func syncDir(dir string) error {
+	return nil
+}
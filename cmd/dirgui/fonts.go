@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"io/ioutil"
+	"strings"
+)
+
+var fontPaths = flag.String("font", "", "Comma-separated TrueType or OpenType fonts to draw text with, in order of preference; Go Regular is always used last")
+var fontSize = flag.Float64("font_size", 13, "Font size in pixels")
+
+// fonts are the parsed fonts, in order of preference.
+var fonts []*sfnt.Font
+
+// Vertical metrics of a line of text in pixels, set by loadFonts
+var (
+	lineHeight int
+	baseline   int // from the top of a line
+)
+
+// loadFonts parses the fonts named by -font, followed by the bundled Go Regular.
+func loadFonts() error {
+	fonts = nil
+	if *fontPaths != "" {
+		for _, path := range strings.Split(*fontPaths, ",") {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("couldn't read font: %v", err)
+			}
+			f, err := opentype.Parse(data)
+			if err != nil {
+				return fmt.Errorf("couldn't parse font %q: %v", path, err)
+			}
+			fonts = append(fonts, f)
+		}
+	}
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return fmt.Errorf("couldn't parse Go Regular: %v", err)
+	}
+	fonts = append(fonts, f)
+
+	face := newFace()
+	metrics := face.Metrics()
+	baseline = metrics.Ascent.Ceil() + 2
+	lineHeight = baseline + metrics.Descent.Ceil() + 2
+	return nil
+}
+
+// newFace returns a face that draws each character with the first font that has it. Faces aren't safe for concurrent use, so each session makes its own.
+func newFace() font.Face {
+	var face fallbackFace
+	for _, f := range fonts {
+		ff, err := opentype.NewFace(f, &opentype.FaceOptions{Size: *fontSize, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			panic(fmt.Sprintf("couldn't create face: %v", err)) // only possible with invalid options
+		}
+		face.fonts = append(face.fonts, f)
+		face.faces = append(face.faces, ff)
+	}
+	return &face
+}
+
+// fallbackFace is a font.Face made of several faces, so that characters missing from one can come from another. The first face determines the metrics.
+type fallbackFace struct {
+	fonts []*sfnt.Font
+	faces []font.Face
+	buf   sfnt.Buffer
+}
+
+// pick returns the index of the first face with a glyph for r, or 0 if none have it.
+func (f *fallbackFace) pick(r rune) int {
+	for i, ff := range f.fonts {
+		if idx, err := ff.GlyphIndex(&f.buf, r); err == nil && idx != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	return f.faces[f.pick(r)].Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	return f.faces[f.pick(r)].GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	return f.faces[f.pick(r)].GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	i := f.pick(r0)
+	if i != f.pick(r1) {
+		return 0
+	}
+	return f.faces[i].Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// textWidth returns the width of text in pixels.
+func textWidth(face font.Face, text string) int {
+	return font.MeasureString(face, text).Ceil()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// maxFrameSize is the largest width or height of a frame from a frame-stream editor.
+const maxFrameSize = 4096
+
+// pngHeaderLength is the length of a PNG's signature and IHDR chunk, which holds its size.
+const pngHeaderLength = 8 + 4 + 4 + 13 + 4
+
+type pointerMessage struct {
+	Type    string `json:"type"` // "pointer"
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Buttons uint8  `json:"buttons"`
+}
+
+type keyMessage struct {
+	Type   string `json:"type"` // "key"
+	KeySym uint32 `json:"keysym"`
+	Down   bool   `json:"down"`
+	Text   string `json:"text,omitempty"` // character typed by the key, if any
+}
+
+// nestFrames runs cmd as a frame-stream editor, which is simpler to write than an RFB server. The editor writes a sequence of PNG or binary PPM (P6) images to stdout, and the first image's size is the size of its GUI. Input events (rfb.PointerEvent and rfb.KeyEvent values from events) are written to its stdin as JSON, one per line:
+//
+//	{"type":"pointer","x":10,"y":20,"buttons":1}
+//	{"type":"key","keysym":97,"down":true,"text":"a"}
+func nestFrames(cmd *exec.Cmd, imgs chan image.Image, events chan interface{}) (image.Rectangle, error) {
+	cmd.Stdout = nil
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return image.ZR, fmt.Errorf("couldn't create stdout pipe: %v", err)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return image.ZR, fmt.Errorf("couldn't create stdin pipe: %v", err)
+	}
+
+	log.Printf("starting frame-stream subprocess %s…", cmd.Path)
+	if err := cmd.Start(); err != nil {
+		return image.ZR, fmt.Errorf("couldn't start subprocess: %v", err)
+	}
+
+	r := bufio.NewReader(stdout)
+	firstErr := make(chan error, 1)
+	var first image.Image
+	go func() {
+		var err error
+		first, err = readFrame(r)
+		firstErr <- err
+	}()
+	select {
+	case err := <-firstErr:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return image.ZR, fmt.Errorf("couldn't read first frame: %v", err)
+		}
+	case <-time.After(editorTimeout):
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return image.ZR, fmt.Errorf("editor didn't write a frame within %v", editorTimeout)
+	}
+	bounds := first.Bounds().Sub(first.Bounds().Min)
+
+	go func() {
+		defer close(imgs)
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+
+		for img := first; ; {
+			imgs <- img
+
+			var err error
+			if img, err = readFrame(r); err == io.EOF {
+				return
+			} else if err != nil {
+				log.Printf("[nestFrames] couldn't read frame: %v", err)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer stdin.Close() // once events is closed
+		enc := json.NewEncoder(stdin)
+		for event := range events {
+			var msg interface{}
+			switch e := event.(type) {
+			case rfb.PointerEvent:
+				msg = pointerMessage{Type: "pointer", X: int(e.X), Y: int(e.Y), Buttons: e.ButtonMask}
+			case rfb.KeyEvent:
+				km := keyMessage{Type: "key", KeySym: e.KeySym, Down: e.Pressed}
+				if r, ok := rfb.KeySymRune(e.KeySym); ok {
+					km.Text = string(r)
+				}
+				msg = km
+			}
+			if err := enc.Encode(msg); err != nil {
+				log.Printf("[nestFrames] couldn't write event: %v", err)
+				return
+			}
+		}
+	}()
+
+	return bounds, nil
+}
+
+// readFrame reads one PNG or PPM image from r, returning io.EOF if there are no more.
+func readFrame(r *bufio.Reader) (image.Image, error) {
+	magic, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch magic[0] {
+	case 0x89:
+		// Check the size in the IHDR chunk, which follows the signature, before decoding.
+		header, err := r.Peek(pngHeaderLength)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read PNG header: %v", err)
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(header))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse PNG header: %v", err)
+		}
+		if config.Width > maxFrameSize || config.Height > maxFrameSize {
+			return nil, fmt.Errorf("PNG width and height must be at most %d, but they're %d and %d", maxFrameSize, config.Width, config.Height)
+		}
+		return png.Decode(r)
+	case 'P':
+		return readPPM(r)
+	default:
+		return nil, fmt.Errorf("frame must be PNG or PPM, but it starts with %q", magic)
+	}
+}
+
+// readPPM reads a binary PPM image (P6).
+func readPPM(r *bufio.Reader) (image.Image, error) {
+	var header [4]int
+	magic, err := ppmToken(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read PPM header: %v", err)
+	}
+	if magic != "P6" {
+		return nil, fmt.Errorf("only binary PPM (P6) is supported, but found %q", magic)
+	}
+	for i := 1; i < len(header); i++ {
+		token, err := ppmToken(r)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read PPM header: %v", err)
+		}
+		if header[i], err = strconv.Atoi(token); err != nil {
+			return nil, fmt.Errorf("couldn't parse PPM header: %v", err)
+		}
+	}
+	width, height, maxval := header[1], header[2], header[3]
+	if width <= 0 || height <= 0 || width > maxFrameSize || height > maxFrameSize {
+		return nil, fmt.Errorf("PPM width and height must be between 1 and %d, but they're %d and %d", maxFrameSize, width, height)
+	}
+	if maxval <= 0 || maxval > 65535 {
+		return nil, fmt.Errorf("PPM maxval must be between 1 and 65535, but it's %d", maxval)
+	}
+
+	sampleSize := 1
+	if maxval > 255 {
+		sampleSize = 2
+	}
+	pix := make([]byte, width*height*3*sampleSize)
+	if _, err := io.ReadFull(r, pix); err != nil {
+		return nil, fmt.Errorf("couldn't read PPM pixels: %v", err)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	sample := func(i int) uint8 {
+		var v int
+		if sampleSize == 1 {
+			v = int(pix[i])
+		} else {
+			v = int(pix[2*i])<<8 | int(pix[2*i+1])
+		}
+		return uint8(v * 255 / maxval)
+	}
+	for i := 0; i < width*height; i++ {
+		img.SetNRGBA(i%width, i/width, color.NRGBA{sample(3 * i), sample(3*i + 1), sample(3*i + 2), 0xff})
+	}
+	return img, nil
+}
+
+// ppmToken reads a whitespace-delimited token from a PPM header, skipping comments. It consumes the single whitespace character that follows the token.
+func ppmToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#' && len(token) == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+}
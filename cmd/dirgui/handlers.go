@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var handlersPath = flag.String("handlers", "", "Handlers file to use instead of $XDG_CONFIG_HOME/dirgui/handlers")
+
+// dirHandlersName is the name of a directory's own handlers file, which overrides the user's handlers.
+const dirHandlersName = ".dirgui-handlers"
+
+// A handler associates files with a custom editor, in the spirit of a mailcap entry. Each line of a handlers file looks like
+//
+//	image/gif; dirgui-gif
+//	*.gif; /usr/local/bin/dirgui-gif
+//	*.csv; ./plot-csv.py; frames
+//
+// The pattern is a MIME type (possibly with a * subtype) if it contains a slash, otherwise a filename glob. The command is looked up in $PATH unless it contains a slash, in which case it's relative to the handlers file. The optional "frames" flag marks editors that speak the frame-stream protocol (see nestFrames) instead of RFB.
+type handler struct {
+	pattern string
+	command string
+	frames  bool
+}
+
+// loadHandlers returns the handlers that apply to files in dir, in order of precedence.
+func loadHandlers(dir string) []handler {
+	paths := []string{filepath.Join(dir, dirHandlersName)}
+	if *handlersPath != "" {
+		paths = append(paths, *handlersPath)
+	} else {
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				configHome = filepath.Join(home, ".config")
+			}
+		}
+		if configHome != "" {
+			paths = append(paths, filepath.Join(configHome, "dirgui", "handlers"))
+		}
+	}
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, configDir := range filepath.SplitList(configDirs) {
+		paths = append(paths, filepath.Join(configDir, "dirgui", "handlers"))
+	}
+
+	var handlers []handler
+	for _, path := range paths {
+		fileHandlers, err := readHandlers(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Printf("couldn't read handlers: %v", err)
+			continue
+		}
+		handlers = append(handlers, fileHandlers...)
+	}
+	return handlers
+}
+
+func readHandlers(path string) ([]handler, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var handlers []handler
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"pattern; command\", but found %q", path, lineno, line)
+		}
+		h := handler{pattern: strings.TrimSpace(fields[0]), command: strings.TrimSpace(fields[1])}
+		for _, flag := range fields[2:] {
+			switch flag = strings.TrimSpace(flag); flag {
+			case "frames":
+				h.frames = true
+			default:
+				return nil, fmt.Errorf("%s:%d: unrecognized flag %q", path, lineno, flag)
+			}
+		}
+		if strings.Contains(h.command, "/") && !filepath.IsAbs(h.command) {
+			// Editors run in the form's directory, so they need absolute paths.
+			if h.command, err = filepath.Abs(filepath.Join(filepath.Dir(path), h.command)); err != nil {
+				return nil, fmt.Errorf("%s:%d: couldn't resolve %q: %v", path, lineno, fields[1], err)
+			}
+		}
+		handlers = append(handlers, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read %q: %v", path, err)
+	}
+	return handlers, nil
+}
+
+// findHandler returns the editor for the named file in dir with its command resolved to a path, or nil if there isn't one.
+func findHandler(handlers []handler, dir, name string) *handler {
+	var mimeType string
+	for _, h := range handlers {
+		var matched bool
+		if strings.Contains(h.pattern, "/") {
+			if mimeType == "" {
+				mimeType = sniffMIMEType(filepath.Join(dir, name))
+			}
+			matched = matchMIMEType(h.pattern, mimeType)
+		} else {
+			matched, _ = filepath.Match(h.pattern, name)
+		}
+		if !matched {
+			continue
+		}
+
+		path, err := exec.LookPath(h.command)
+		if err != nil {
+			log.Printf("couldn't find editor for %q: %v", name, err)
+			continue
+		}
+		return &handler{pattern: h.pattern, command: path, frames: h.frames}
+	}
+	return nil
+}
+
+func sniffMIMEType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+	mimeType := http.DetectContentType(buf[:n])
+	if mimeType == "application/octet-stream" || strings.HasPrefix(mimeType, "text/plain") {
+		if byExtension := mime.TypeByExtension(filepath.Ext(path)); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	return mediaType
+}
+
+func matchMIMEType(pattern, mimeType string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == mimeType
+}
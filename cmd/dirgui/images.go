@@ -0,0 +1,50 @@
+package main
+
+import (
+	xdraw "golang.org/x/image/draw"
+	"image"
+	"log"
+	"os"
+
+	// Image formats shown by the image viewer
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// loadImage decodes the image file at path, scaled down to fit in width pixels, or returns nil if it isn't an image in a known format.
+func loadImage(path string, width int) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	if _, _, err := image.DecodeConfig(f); err != nil {
+		return nil // not an image
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		log.Printf("couldn't rewind %q: %v", path, err)
+		return nil
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		log.Printf("couldn't decode image %q: %v", path, err)
+		return nil
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, xdraw.Src, nil)
+	return scaled
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/alltom/dirgui/rfb"
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"image"
 	"io"
@@ -16,6 +17,10 @@ import (
 func main() {
 	flag.Parse()
 
+	if err := loadFonts(); err != nil {
+		log.Fatalf("couldn't load fonts: %v", err)
+	}
+
 	ln, err := net.Listen("tcp", "127.0.0.1:5900")
 	if err != nil {
 		log.Fatalf("couldn't listen: %v", err)
@@ -59,7 +64,14 @@ func rfbServe(conn io.ReadWriter) error {
 	var updateRequest rfb.FramebufferUpdateRequest
 	var update rfb.FramebufferUpdate
 	var keyEvent rfb.KeyEvent
-	var pointerEvent rfb.PointerEvent
+	var s session
+	if conn, ok := conn.(net.Conn); ok {
+		s.client = conn.RemoteAddr().String()
+	}
+	var cursorEncoding uint32 // RawEncoding if the client can't draw custom cursors
+	var lastCursor *rfb.Cursor
+	var canResize bool     // whether the client accepts DesktopSize updates
+	var fbSize image.Point // size of the client's framebuffer
 
 	if _, err := io.WriteString(conn, "RFB 003.008\n"); err != nil {
 		return fmt.Errorf("couldn't write ProtocolVersion: %v", err)
@@ -113,10 +125,11 @@ func rfbServe(conn io.ReadWriter) error {
 		return fmt.Errorf("couldn't read ClientInit: %v", err)
 	}
 
-	windowRect := updateUI(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
+	windowRect := updateUI(&s, image.NewNRGBA(image.ZR), nil)
 	if windowRect.Min != image.Pt(0, 0) {
 		panic(fmt.Sprintf("window origin must be (0, 0), but it's %v", windowRect.Min))
 	}
+	fbSize = windowRect.Size()
 	bo.PutUint16(buf[0:], uint16(windowRect.Dx())) // width
 	bo.PutUint16(buf[2:], uint16(windowRect.Dy())) // height
 	pixelFormat.Write(buf[4:], bo)
@@ -136,6 +149,7 @@ func rfbServe(conn io.ReadWriter) error {
 				return fmt.Errorf("couldn't read pixel format in SetPixelFormat: %v", err)
 			}
 			pixelFormat.Read(buf[3:], bo)
+			lastCursor = nil // resend in the new pixel format
 
 		case 2: // SetEncodings
 			if _, err := io.ReadFull(conn, buf[:3]); err != nil {
@@ -154,20 +168,63 @@ func rfbServe(conn io.ReadWriter) error {
 			}
 			log.Printf("client requested one of %d encodings: %v", encodingCount, requestedEncodings)
 
+			// Prefer the full-color cursor, since both are usually offered.
+			cursorEncoding = rfb.RawEncoding
+			for _, encoding := range requestedEncodings {
+				if uint32(encoding) == rfb.CursorPseudoEncoding || (uint32(encoding) == rfb.XCursorPseudoEncoding && cursorEncoding == rfb.RawEncoding) {
+					cursorEncoding = uint32(encoding)
+				}
+			}
+			lastCursor = nil
+
+			canResize = false
+			for _, encoding := range requestedEncodings {
+				if uint32(encoding) == rfb.DesktopSizePseudoEncoding {
+					canResize = true
+				}
+			}
+
 		case 3: // FramebufferUpdateRequest
 			if _, err := io.ReadFull(conn, buf[:rfb.FramebufferUpdateRequestEncodingLength]); err != nil {
 				return fmt.Errorf("couldn't read FramebufferUpdateRequest: %v", err)
 			}
 			updateRequest.Read(buf, bo)
 
-			img := rfb.NewPixelFormatImage(pixelFormat, image.Rect(int(updateRequest.X), int(updateRequest.Y), int(updateRequest.X)+int(updateRequest.Width), int(updateRequest.Y)+int(updateRequest.Height)))
-			updateUI(img, &keyEvent, &pointerEvent)
+			rect := image.Rect(int(updateRequest.X), int(updateRequest.Y), int(updateRequest.X)+int(updateRequest.Width), int(updateRequest.Y)+int(updateRequest.Height))
+			rect = rect.Intersect(image.Rectangle{Max: fbSize}) // in case the request predates a resize
+			img := rfb.NewPixelFormatImage(pixelFormat, rect)
+			windowRect := updateUI(&s, img, nil)
 			update.Rectangles = []*rfb.FramebufferUpdateRect{
 				&rfb.FramebufferUpdateRect{
-					X: updateRequest.X, Y: updateRequest.Y, Width: updateRequest.Width, Height: updateRequest.Height,
-					EncodingType: 0, PixelData: img.Pix,
+					X: uint16(rect.Min.X), Y: uint16(rect.Min.Y), Width: uint16(rect.Dx()), Height: uint16(rect.Dy()),
+					EncodingType: rfb.RawEncoding, PixelData: img.Pix,
 				},
 			}
+			if windowRect.Size() != fbSize && canResize {
+				// The form changed size, such as by navigating to another directory, so resize the client and redraw it all.
+				fbSize = windowRect.Size()
+				img = rfb.NewPixelFormatImage(pixelFormat, windowRect)
+				updateUI(&s, img, nil)
+				update.Rectangles = []*rfb.FramebufferUpdateRect{
+					&rfb.FramebufferUpdateRect{
+						Width: uint16(fbSize.X), Height: uint16(fbSize.Y),
+						EncodingType: rfb.DesktopSizePseudoEncoding,
+					},
+					&rfb.FramebufferUpdateRect{
+						Width: uint16(fbSize.X), Height: uint16(fbSize.Y),
+						EncodingType: rfb.RawEncoding, PixelData: img.Pix,
+					},
+				}
+			}
+			if s.cursor != lastCursor {
+				switch cursorEncoding {
+				case rfb.CursorPseudoEncoding:
+					update.Rectangles = append(update.Rectangles, s.cursor.Rect(pixelFormat))
+				case rfb.XCursorPseudoEncoding:
+					update.Rectangles = append(update.Rectangles, s.cursor.XRect())
+				}
+				lastCursor = s.cursor
+			}
 
 			if _, err := w.Write([]byte{0, 0}); err != nil { // message type and padding
 				return fmt.Errorf("couldn't write FramebufferUpdate header: %v", err)
@@ -184,14 +241,14 @@ func rfbServe(conn io.ReadWriter) error {
 				return fmt.Errorf("couldn't read KeyEvent: %v", err)
 			}
 			keyEvent.Read(buf, bo)
-			updateUI(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
+			updateUI(&s, image.NewNRGBA(image.ZR), &keyEvent)
 
 		case 5: // PointerEvent
 			if _, err := io.ReadFull(conn, buf[:rfb.PointerEventEncodingLength]); err != nil {
 				return fmt.Errorf("couldn't read PointerEvent: %v", err)
 			}
-			pointerEvent.Read(buf, bo)
-			updateUI(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
+			s.pointerEvent.Read(buf, bo)
+			updateUI(&s, image.NewNRGBA(image.ZR), nil)
 
 		case 6: // ClientCutText
 			if _, err := io.ReadFull(conn, buf[:7]); err != nil {
@@ -214,7 +271,25 @@ func rfbServe(conn io.ReadWriter) error {
 		default:
 			return fmt.Errorf("received unrecognized message %d", buf[0])
 		}
-	}
 
-	return nil
+		if s.cutText != "" {
+			text, err := encoding.ReplaceUnsupported(charmap.ISO8859_1.NewEncoder()).String(s.cutText)
+			if err != nil {
+				return fmt.Errorf("couldn't convert text to Latin-1 for ServerCutText: %v", err)
+			}
+			s.cutText = ""
+			buf[0] = 3 // ServerCutText
+			buf[1], buf[2], buf[3] = 0, 0, 0
+			bo.PutUint32(buf[4:], uint32(len(text)))
+			if _, err := w.Write(buf[:8]); err != nil {
+				return fmt.Errorf("couldn't write ServerCutText header: %v", err)
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				return fmt.Errorf("couldn't write ServerCutText text: %v", err)
+			}
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("couldn't write ServerCutText: %v", err)
+			}
+		}
+	}
 }
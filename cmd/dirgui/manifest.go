@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// manifestName is the name of a directory's manifest, which customizes its form.
+const manifestName = ".dirgui.json"
+
+// A manifest customizes the form for a directory. For example:
+//
+//	{
+//		"sections": [
+//			{"title": "Server", "help": "Restart after making changes.", "files": ["host", "port", "restart.sh"]},
+//			{"title": "Logs", "files": ["tail-log.sh"]}
+//		],
+//		"files": {
+//			"port": {"label": "Port", "help": "Which port to listen on", "type": "number", "min": 1, "max": 65535},
+//			"hostname": {"pattern": "^[a-z0-9.-]+$", "validator": "./resolves.sh"},
+//			"config": {"json": true},
+//			"restart.sh": {"label": "Restart", "timeout": "30s"},
+//			"notes": {"type": "textarea"},
+//			"verbosity": {"type": "slider", "min": 0, "max": 5},
+//			"color": {"options": ["red", "green", "blue"]},
+//			"format.sh": {"stdin": "draft", "stdout": "formatted"},
+//			"scratch": {"hidden": true}
+//		}
+//	}
+//
+// Files in sections are shown in the order they're listed, under their section's title and help, if any. The files that aren't in any section follow in the usual order.
+type manifest struct {
+	Sections []manifestSection       `json:"sections"`
+	Files    map[string]fileSettings `json:"files"`
+}
+
+type manifestSection struct {
+	Title string   `json:"title"`
+	Help  string   `json:"help"`
+	Files []string `json:"files"`
+}
+
+// fileSettings customize the widget for one file.
+type fileSettings struct {
+	Label     string `json:"label"`     // shown instead of the file name
+	Help      string `json:"help"`      // shown under the label
+	Type      string `json:"type"`      // kind of field to edit the file in, even if it's executable, an image, or has a custom editor: "field", "textarea" (which is always a text area), "checkbox", "number", "slider", or "dropdown"
+	Hidden    bool   `json:"hidden"`    // leave the file out of the form
+	Pattern   string `json:"pattern"`   // regular expression that the field's value must match to be saved
+	JSON      bool   `json:"json"`      // whether the field's value must be valid JSON to be saved
+	Validator string `json:"validator"` // executable that checks the field's value (see validatorSuffix), relative to the directory, instead of <name>.validate
+	Stdin     string `json:"stdin"`     // field to pipe to the executable, instead of <name>.stdin
+	Stdout    string `json:"stdout"`    // field to put the executable's output in, instead of <name>.stdout
+	Timeout   string `json:"timeout"`   // how long the executable may run, instead of -timeout or <name>.timeout
+
+	Options []string `json:"options"` // for a dropdown, instead of <name>.options
+	Min     *float64 `json:"min"`     // for a slider or spin box, with max, instead of <name>.range
+	Max     *float64 `json:"max"`
+	Step    float64  `json:"step"`
+
+	pattern *regexp.Regexp
+	timeout time.Duration
+}
+
+// forcesField reports whether the settings force the file to be edited in a field, even if it's executable, an image, or has a custom editor.
+func (settings fileSettings) forcesField() bool {
+	return settings.Type != ""
+}
+
+// loadManifest reads the manifest in dir, returning an empty one if there isn't one or it's invalid. Invalid settings are logged and ignored.
+func loadManifest(dir string) *manifest {
+	var m manifest
+	path := filepath.Join(dir, manifestName)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &m
+	} else if err != nil {
+		log.Printf("couldn't read manifest: %v", err)
+		return &m
+	}
+	if err := json.Unmarshal(content, &m); err != nil {
+		log.Printf("couldn't parse manifest %q: %v", path, err)
+		return &manifest{}
+	}
+
+	for name, settings := range m.Files {
+		if _, ok := fieldKinds[settings.Type]; !ok && settings.Type != "" {
+			log.Printf("unknown type %q for %q in %q", settings.Type, name, path)
+			settings.Type = ""
+		}
+		if settings.Pattern != "" {
+			if settings.pattern, err = regexp.Compile(settings.Pattern); err != nil {
+				log.Printf("couldn't parse pattern for %q in %q: %v", name, path, err)
+			}
+		}
+		if settings.Min != nil && settings.Max != nil && *settings.Min >= *settings.Max {
+			log.Printf("min must be less than max for %q in %q", name, path)
+			settings.Min, settings.Max = nil, nil
+		}
+		if settings.Timeout != "" {
+			if settings.timeout, err = time.ParseDuration(settings.Timeout); err != nil {
+				log.Printf("couldn't parse timeout for %q in %q: %v", name, path, err)
+			}
+		}
+		m.Files[name] = settings
+	}
+	return &m
+}
+
+// arrange orders widgets by the sections they're in, followed by the ones that aren't in any, and records their sections.
+func (m *manifest) arrange(widgets []*Widget) []*Widget {
+	remaining := make(map[string]*Widget)
+	for _, widget := range widgets {
+		remaining[widget.fileInfo.Name()] = widget
+	}
+
+	arranged := make([]*Widget, 0, len(widgets))
+	for i := range m.Sections {
+		section := &m.Sections[i]
+		for _, name := range section.Files {
+			if widget := remaining[name]; widget != nil {
+				delete(remaining, name)
+				widget.section = section
+				arranged = append(arranged, widget)
+			}
+		}
+	}
+	for _, widget := range widgets {
+		if remaining[widget.fileInfo.Name()] != nil {
+			widget.section = nil
+			arranged = append(arranged, widget)
+		}
+	}
+	return arranged
+}
+
+// label is the name shown for widget's file.
+func (widget *Widget) label() string {
+	if widget.settings.Label != "" {
+		return widget.settings.Label
+	}
+	return widget.fileInfo.Name()
+}
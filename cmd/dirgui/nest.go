@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+var nestTCP = flag.Bool("nest_tcp", false, "Connect RFB editors over TCP with --parent_vnc_addr, for editors that don't support $DIRGUI_SOCKET")
+
+// editorTimeout is how long a custom editor has to connect, or to write its first frame, before it's killed.
+const editorTimeout = 10 * time.Second
+
+// startUnixEditor starts cmd and returns its connection. The editor must connect to the Unix socket named by $DIRGUI_SOCKET and write $DIRGUI_TOKEN and a newline before speaking RFB. The socket lives in a directory only this user can access, and the token keeps other processes of the same user from impersonating the editor.
+func startUnixEditor(cmd *exec.Cmd) (net.Conn, error) {
+	dir, err := ioutil.TempDir("", "dirgui")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create socket directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "editor.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen: %v", err)
+	}
+	defer ln.Close()
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("couldn't generate token: %v", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	log.Printf("starting subprocess at %s…", path)
+	cmd.Env = append(os.Environ(), "DIRGUI_SOCKET="+path, "DIRGUI_TOKEN="+token)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't start subprocess: %v", err)
+	}
+
+	return acceptEditor(ln, cmd, token)
+}
+
+// startTCPEditor starts cmd with --parent_vnc_addr and returns the first connection to that address.
+func startTCPEditor(cmd *exec.Cmd) (net.Conn, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen: %v", err)
+	}
+	defer ln.Close()
+
+	log.Printf("starting subprocess at %s…", ln.Addr().String())
+	cmd.Args = append([]string{cmd.Args[0], "--parent_vnc_addr", ln.Addr().String()}, cmd.Args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't start subprocess: %v", err)
+	}
+
+	return acceptEditor(ln, cmd, "")
+}
+
+// acceptEditor returns the first connection to ln from the editor started as cmd, which must start with token and a newline unless token is "". If the editor exits or editorTimeout passes first, it fails, and the editor is killed.
+func acceptEditor(ln net.Listener, cmd *exec.Cmd, token string) (net.Conn, error) {
+	exited := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("editor %s exited: %v", cmd.Path, err)
+		}
+		close(exited)
+		ln.Close()
+	}()
+
+	deadline := time.Now().Add(editorTimeout)
+	if err := ln.(interface{ SetDeadline(time.Time) error }).SetDeadline(deadline); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("couldn't set deadline: %v", err)
+	}
+
+	log.Print("waiting for subprocess connection…")
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-exited:
+				return nil, fmt.Errorf("editor exited before connecting")
+			default:
+			}
+			cmd.Process.Kill()
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				return nil, fmt.Errorf("editor didn't connect within %v", editorTimeout)
+			}
+			return nil, fmt.Errorf("couldn't accept connection: %v", err)
+		}
+		if token == "" {
+			return conn, nil
+		}
+
+		buf := make([]byte, len(token)+1)
+		conn.SetReadDeadline(deadline)
+		_, err = io.ReadFull(conn, buf)
+		conn.SetReadDeadline(time.Time{})
+		if err == nil && subtle.ConstantTimeCompare(buf, []byte(token+"\n")) == 1 {
+			return conn, nil
+		}
+
+		log.Printf("rejected editor connection with bad token (err: %v)", err)
+		if err := conn.Close(); err != nil {
+			log.Printf("couldn't close connection: %v", err)
+		}
+	}
+}
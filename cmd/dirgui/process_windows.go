@@ -0,0 +1,16 @@
+package main
+
+import "os/exec"
+
+// setProcessGroup does nothing on Windows, where only cmd itself can be stopped.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminate stops cmd. Windows has no SIGTERM, so it's the same as kill.
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// kill stops cmd.
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var historyLength = flag.Int("history", 10, "Number of runs of each executable to remember")
+var defaultTimeout = flag.Duration("timeout", 0, "How long executables may run before they're stopped, unless overridden by a <name>.timeout file next to them; 0 means forever")
+var gracePeriod = flag.Duration("grace_period", 5*time.Second, "How long stopped executables have to exit after SIGTERM before they're sent SIGKILL")
+
+// timeoutSuffix is appended to an executable's name to name the file that holds its timeout.
+const timeoutSuffix = ".timeout"
+
+// Suffixes appended to an executable's name to name the fields paired with it: the contents of the stdin field are piped to it, and its output replaces the contents of the stdout field.
+const (
+	stdinSuffix  = ".stdin"
+	stdoutSuffix = ".stdout"
+)
+
+var (
+	successColor = color.NRGBA{0x1b, 0x80, 0x3a, 0xff}
+	failureColor = color.NRGBA{0xc6, 0x28, 0x28, 0xff}
+)
+
+// run is one run of an executable.
+type run struct {
+	start  time.Time
+	client string // who started it
+	output *console
+	cmd    *exec.Cmd
+
+	lock    sync.Mutex
+	done    bool
+	elapsed time.Duration
+	err     error  // from exec.Cmd.Wait
+	stopped string // why it was stopped, if it was
+
+	button ButtonState // for showing its output from the history
+}
+
+// stop terminates r's process group, killing it if it hasn't exited after the grace period. reason explains why.
+func (r *run) stop(reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done || r.stopped != "" {
+		return
+	}
+	r.stopped = reason
+	if r.cmd == nil {
+		return // still saving fields, so it won't be started
+	}
+
+	if err := terminate(r.cmd); err != nil {
+		log.Printf("couldn't terminate %q: %v", r.cmd.Path, err)
+	}
+	time.AfterFunc(*gracePeriod, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if !r.done {
+			if err := kill(r.cmd); err != nil {
+				log.Printf("couldn't kill %q: %v", r.cmd.Path, err)
+			}
+		}
+	})
+}
+
+// status describes how r ended, or that it's still going.
+func (r *run) status() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.done {
+		if r.cmd == nil {
+			return "saving fields"
+		}
+		if r.stopped != "" {
+			return fmt.Sprintf("stopping, %v", roundDuration(time.Since(r.start)))
+		}
+		return fmt.Sprintf("running, %v", roundDuration(time.Since(r.start)))
+	}
+	if r.stopped != "" {
+		return fmt.Sprintf("%s, %v", r.stopped, roundDuration(r.elapsed))
+	}
+	if r.err == nil {
+		return fmt.Sprintf("exit 0, %v", roundDuration(r.elapsed))
+	}
+	if exitErr, ok := r.err.(*exec.ExitError); ok && exitErr.Exited() {
+		return fmt.Sprintf("exit %d, %v", exitErr.ExitCode(), roundDuration(r.elapsed))
+	}
+	return fmt.Sprintf("%v, %v", r.err, roundDuration(r.elapsed))
+}
+
+func (r *run) color() color.Color {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch {
+	case !r.done:
+		return color.Black
+	case r.err == nil:
+		return successColor
+	default:
+		return failureColor
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// start runs widget's executable on behalf of s, showing its output. The values of the other fields in the form (widgets) are passed to it in the environment, and in submit mode, they're saved first, in the background. formsLock must be held.
+func (widget *Widget) start(s *session, widgets []*Widget) {
+	r := &run{start: time.Now(), client: s.client, output: &console{}}
+	widget.runs = append(widget.runs, r)
+	if len(widget.runs) > *historyLength {
+		widget.runs = widget.runs[len(widget.runs)-*historyLength:]
+	}
+	widget.shownRun = nil
+	widget.showOutput = true
+	widget.outputView = EditorState{readOnly: true, tail: true, atEnd: true}
+	widget.running = true
+
+	if !*submitMode {
+		widget.launch(s, r, widgets)
+		return
+	}
+	saves := editedFields(widgets)
+	go func(widget *Widget, r *run) {
+		err := saveFields(saves)
+		formsLock.Lock()
+		defer formsLock.Unlock()
+		recordSaves(saves, err)
+		if err != nil {
+			widget.finish(r, fmt.Errorf("couldn't save fields: %v", err))
+			return
+		}
+		r.lock.Lock()
+		stopped := r.stopped != ""
+		r.lock.Unlock()
+		if stopped {
+			widget.finish(r, nil)
+			return
+		}
+		widget.launch(s, r, widgets)
+	}(widget, r)
+}
+
+// finish records that r ended without its process exiting, because of err if it isn't nil. formsLock must be held.
+func (widget *Widget) finish(r *run, err error) {
+	r.lock.Lock()
+	r.elapsed = time.Since(r.start)
+	r.err = err
+	r.done = true
+	r.lock.Unlock()
+	widget.running = false
+	if err != nil {
+		log.Printf("%s couldn't run %q: %v", r.client, widget.path(), err)
+	}
+}
+
+// launch starts r's process for start. formsLock must be held.
+func (widget *Widget) launch(s *session, r *run, widgets []*Widget) {
+	out := io.MultiWriter(os.Stdout, r.output)
+	r.cmd = &exec.Cmd{Path: widget.fileInfo.Name(), Dir: widget.dir, Env: runEnv(s, widget.dir, widgets), Stdout: out, Stderr: out}
+	stdinField, stdoutField := widget.pairedFields(widgets)
+	if stdinField != nil {
+		if stdinField.loaded || stdinField.dirty() {
+			r.cmd.Stdin = strings.NewReader(stdinField.fileContent())
+		} else if f, err := os.Open(stdinField.path()); err != nil {
+			log.Printf("couldn't open %q: %v", stdinField.path(), err)
+		} else {
+			defer f.Close() // the process inherits its own descriptor once started
+			r.cmd.Stdin = f
+		}
+	}
+	var stdout bytes.Buffer
+	if stdoutField != nil {
+		r.cmd.Stdout = io.MultiWriter(&stdout, r.output)
+	}
+	setProcessGroup(r.cmd)
+	if err := r.cmd.Start(); err != nil {
+		widget.finish(r, err)
+		return
+	}
+
+	var timer *time.Timer
+	if timeout := widget.timeout(); timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			r.stop(fmt.Sprintf("timed out after %v", timeout))
+		})
+	}
+	go func(widget *Widget, r *run, path string) {
+		err := r.cmd.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+		r.lock.Lock()
+		r.elapsed = time.Since(r.start)
+		r.err = err
+		r.done = true
+		r.lock.Unlock()
+		log.Printf("%s ran %q: %s", r.client, path, r.status())
+
+		formsLock.Lock()
+		defer formsLock.Unlock()
+		if stdoutField != nil && err == nil {
+			stdoutField.setText(stdout.String()) // left unsaved, like an edit
+		}
+		if *submitMode {
+			reloadFields(widgets) // to show what it changed
+		}
+		widget.running = false
+	}(widget, r, widget.path())
+}
+
+// runEnv returns the environment for running an executable in dir on behalf of s: dirgui's own environment, plus
+//
+//	DIRGUI_DIR, the absolute path of dir
+//	DIRGUI_CLIENT, the address of the client that started it
+//	DIRGUI_FIELD_<NAME>, the current value of each field in widgets, even if it hasn't been saved (see value)
+func runEnv(s *session, dir string, widgets []*Widget) []string {
+	env := os.Environ()
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	env = append(env, "DIRGUI_DIR="+dir, "DIRGUI_CLIENT="+s.client)
+	for _, widget := range widgets {
+		if widget.isField() {
+			env = append(env, "DIRGUI_FIELD_"+envName(widget.fileInfo.Name())+"="+widget.value())
+		}
+	}
+	return env
+}
+
+// value returns the field's current value: what's in it if it's been loaded or edited, or else what's in its file, as the field would show it.
+func (widget *Widget) value() string {
+	if widget.loaded || widget.dirty() {
+		return widget.content
+	}
+	path := widget.path()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("couldn't read %q: %v", path, err)
+		return ""
+	}
+	text, _, _ := widget.fieldText(string(content))
+	return text
+}
+
+// envName converts a file name to a conventional environment variable name, like "MY_FIELD_TXT" for "my-field.txt".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// pairedFields returns the fields in widgets that the manifest pairs with widget's executable, or else that are named for it with stdinSuffix and stdoutSuffix, or nil for those that don't exist.
+func (widget *Widget) pairedFields(widgets []*Widget) (stdin, stdout *Widget) {
+	stdinName, stdoutName := widget.settings.Stdin, widget.settings.Stdout
+	if stdinName == "" {
+		stdinName = widget.fileInfo.Name() + stdinSuffix
+	}
+	if stdoutName == "" {
+		stdoutName = widget.fileInfo.Name() + stdoutSuffix
+	}
+	for _, w := range widgets {
+		if !w.isField() {
+			continue
+		}
+		switch w.fileInfo.Name() {
+		case stdinName:
+			stdin = w
+		case stdoutName:
+			stdout = w
+		}
+	}
+	return stdin, stdout
+}
+
+// timeout returns how long widget's executable may run, from the manifest, its timeout file, or else -timeout.
+func (widget *Widget) timeout() time.Duration {
+	if widget.settings.timeout != 0 {
+		return widget.settings.timeout
+	}
+	path := widget.path() + timeoutSuffix
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return *defaultTimeout
+	} else if err != nil {
+		log.Printf("couldn't read timeout: %v", err)
+		return *defaultTimeout
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(string(content)))
+	if err != nil {
+		log.Printf("couldn't parse timeout in %q: %v", path, err)
+		return *defaultTimeout
+	}
+	return timeout
+}
+
+// executable draws the button for an executable starting at y, along with the status of its latest run, the history of runs, and the output of the selected one. It returns the bottom of what it drew. widgets are the other widgets in its form.
+func executable(s *session, widget *Widget, widgets []*Widget, y int, img draw.Image, keyEvent *rfb.KeyEvent) int {
+	face := s.face()
+	buttonHeight := lineHeight + 8
+	textTop := y + (buttonHeight-lineHeight)/2
+
+	label := widget.label()
+	if widget.running {
+		label += "..."
+	}
+	width := buttonWidth(face, widget.label()+"...")
+	if button(s, &widget.button1, label, image.Rect(8, y, 8+width, y+buttonHeight), img, keyEvent) && !widget.running {
+		widget.start(s, widgets)
+	}
+	if len(widget.runs) == 0 {
+		return help(s, widget.settings.Help, y+buttonHeight+4, img) - 4
+	}
+
+	latest := widget.runs[len(widget.runs)-1]
+	x := 8 + width + 8
+	if widget.running {
+		rect := image.Rect(x, y, x+buttonWidth(face, "Stop"), y+buttonHeight)
+		if button(s, &widget.button5, "Stop", rect, img, keyEvent) {
+			latest.stop("stopped by " + s.client)
+		}
+		x = rect.Max.X + 8
+	}
+	coloredLabel(s, latest.status(), latest.color(), image.Rect(x, textTop, windowWidth-8, textTop+lineHeight), img)
+	y = help(s, widget.settings.Help, y+buttonHeight+4, img) - 4
+
+	shown := widget.shownRun
+	if shown == nil {
+		shown = latest
+	}
+	output := shown.output.String()
+
+	x = 8
+	toggle := "Hide output"
+	if !widget.showOutput {
+		toggle = "Show output"
+	}
+	rect := image.Rect(x, y, x+textWidth(face, toggle), y+lineHeight+4)
+	if link(s, &widget.button2, toggle, rect, img, keyEvent) {
+		widget.showOutput = !widget.showOutput
+	}
+	x = rect.Max.X + 8
+	rect = image.Rect(x, y, x+textWidth(face, "Copy"), y+lineHeight+4)
+	if link(s, &widget.button3, "Copy", rect, img, keyEvent) {
+		if start, end := widget.outputView.selection(); start < end && end <= len(output) {
+			s.cutText = output[start:end]
+		} else {
+			s.cutText = output
+		}
+	}
+	x = rect.Max.X + 8
+	toggle = fmt.Sprintf("History (%d)", len(widget.runs))
+	if widget.showHistory {
+		toggle = "Hide history"
+	}
+	rect = image.Rect(x, y, x+textWidth(face, toggle), y+lineHeight+4)
+	if link(s, &widget.button4, toggle, rect, img, keyEvent) {
+		widget.showHistory = !widget.showHistory
+	}
+	y = rect.Max.Y
+
+	if widget.showHistory {
+		for i := len(widget.runs) - 1; i >= 0; i-- {
+			r := widget.runs[i]
+			text := fmt.Sprintf("%s  %s  %s", r.start.Format("Jan 2 15:04:05"), r.client, r.status())
+			if r == shown {
+				text = "• " + text
+			}
+			rect := image.Rect(16, y, 16+textWidth(face, text), y+lineHeight+4)
+			if link(s, &r.button, text, rect, img, keyEvent) {
+				if r == latest {
+					widget.shownRun = nil // follow the next run
+				} else {
+					widget.shownRun = r
+				}
+				widget.showOutput = true
+				widget.outputView = EditorState{readOnly: true, tail: true, atEnd: true}
+			}
+			y = rect.Max.Y
+		}
+	}
+
+	if widget.showOutput {
+		y += 4
+		rect := image.Rect(8, y, windowWidth-8, y+textAreaRows*lineHeight+8)
+		textArea(s, &widget.outputView, &output, rect, img, keyEvent)
+		y = rect.Max.Y
+	}
+	return y
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"github.com/alltom/dirgui/rfb"
+	"golang.org/x/image/font"
+	"image"
+	"path/filepath"
+	"time"
+)
+
+// session is the part of the UI state that belongs to one client connection: its pointer, keyboard focus, and cursor.
+type session struct {
+	client string // address of the client, for telling who did what
+
+	pointerEvent rfb.PointerEvent
+	lastButtons  uint8 // pointerEvent.ButtonMask as of the previous updateUI
+	pressed      uint8 // buttons that went down since the previous updateUI
+	modifiers    rfb.Modifiers
+
+	// Key repeat, for clients that don't repeat held keys themselves
+	heldKey       *rfb.KeyEvent
+	repeatAt      time.Time
+	clientRepeats bool
+
+	focus      interface{}   // state of the focused widget, such as *ButtonState or *EditorState
+	focusables []interface{} // states of focusable widgets in the order they were drawn
+	cursor     *rfb.Cursor   // cursor for the widget under the pointer
+	cutText    string        // text to put on the client's clipboard, if any
+
+	// Viewport
+	scroll         int             // pixels scrolled down
+	scrollDragging bool            // dragging the scroll bar
+	scrollGrab     int             // where the scroll bar's thumb was grabbed, from its top
+	focusRect      image.Rectangle // where the focused widget was drawn
+	revealFocus    bool            // scroll focusRect into view
+
+	fontFace font.Face // see face
+
+	// Navigation
+	dir        string   // directory shown, relative to wdir
+	history    []string // directories to go Back to
+	backButton ButtonState
+	crumbs     []*ButtonState // breadcrumb links
+}
+
+// beginUpdate prepares for drawing a frame, handling Tab navigation with the previous frame's widgets. It returns keyEvent, or nil if it was used up.
+func (s *session) beginUpdate(keyEvent *rfb.KeyEvent) *rfb.KeyEvent {
+	s.pressed = s.pointerEvent.ButtonMask &^ s.lastButtons
+	s.lastButtons = s.pointerEvent.ButtonMask
+	if s.pressed&1 != 0 {
+		s.focus = nil // until a widget under the pointer claims it
+	}
+	s.cursor = arrowCursor
+
+	if keyEvent != nil {
+		s.trackRepeat(keyEvent)
+	} else {
+		keyEvent = s.repeat()
+	}
+
+	if keyEvent != nil && !s.modifiers.Update(*keyEvent) {
+		switch keyEvent.KeySym {
+		case 0xff09: // Tab
+			if keyEvent.Pressed {
+				if s.shift() {
+					s.moveFocus(-1)
+				} else {
+					s.moveFocus(1)
+				}
+			}
+			keyEvent = nil
+		case 0xfe20: // ISO_Left_Tab, which some clients send for Shift+Tab
+			if keyEvent.Pressed {
+				s.moveFocus(-1)
+			}
+			keyEvent = nil
+		}
+	}
+
+	s.focusables = s.focusables[:0]
+	return keyEvent
+}
+
+// face returns the session's own font face, since faces can't be shared between goroutines.
+func (s *session) face() font.Face {
+	if s.fontFace == nil {
+		s.fontFace = newFace()
+	}
+	return s.fontFace
+}
+
+func (s *session) shift() bool {
+	return s.modifiers&rfb.Shift != 0
+}
+
+func (s *session) ctrl() bool {
+	return s.modifiers&rfb.Control != 0
+}
+
+const (
+	repeatDelay    = 500 * time.Millisecond
+	repeatInterval = time.Second / 30
+)
+
+// trackRepeat notes which key is held down so that repeat can repeat it.
+func (s *session) trackRepeat(keyEvent *rfb.KeyEvent) {
+	if rfb.IsModifier(keyEvent.KeySym) {
+		return
+	}
+	if !keyEvent.Pressed {
+		if s.heldKey != nil && s.heldKey.KeySym == keyEvent.KeySym {
+			s.heldKey = nil
+		}
+		return
+	}
+	if s.heldKey != nil && s.heldKey.KeySym == keyEvent.KeySym {
+		s.clientRepeats = true // a second press without a release
+	}
+	held := *keyEvent
+	s.heldKey = &held
+	s.repeatAt = time.Now().Add(repeatDelay)
+}
+
+// repeat returns another press of the held key if it's time for one.
+func (s *session) repeat() *rfb.KeyEvent {
+	if s.heldKey == nil || s.clientRepeats || time.Now().Before(s.repeatAt) {
+		return nil
+	}
+	s.repeatAt = time.Now().Add(repeatInterval)
+	keyEvent := *s.heldKey
+	return &keyEvent
+}
+
+// focusable adds state to the Tab order and reports whether it has focus. Clicking in rect focuses it.
+func (s *session) focusable(state interface{}, rect image.Rectangle) bool {
+	s.focusables = append(s.focusables, state)
+	if s.pressed&1 != 0 && pointerIn(rect, &s.pointerEvent) {
+		s.focus = state
+	}
+	if s.focus == state {
+		s.focusRect = rect
+	}
+	return s.focus == state
+}
+
+// moveFocus moves focus delta places through the Tab order, wrapping around at the ends.
+func (s *session) moveFocus(delta int) {
+	if len(s.focusables) == 0 {
+		return
+	}
+
+	idx := -1
+	for i, state := range s.focusables {
+		if state == s.focus {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 && delta < 0 {
+		idx = 0 // so Shift+Tab starts from the end
+	}
+	idx = (idx + delta + len(s.focusables)) % len(s.focusables)
+	s.focus = s.focusables[idx]
+	s.revealFocus = true
+}
+
+// navigate shows the form for dir (relative to wdir).
+func (s *session) navigate(dir string) {
+	if dir == "." {
+		dir = ""
+	}
+	s.history = append(s.history, s.dir)
+	s.dir = dir
+	s.focus = nil
+	s.scroll = 0
+}
+
+// back returns to the previous directory, or to the parent if there isn't one.
+func (s *session) back() {
+	if len(s.history) > 0 {
+		s.dir = s.history[len(s.history)-1]
+		s.history = s.history[:len(s.history)-1]
+	} else if s.dir != "" {
+		s.dir = filepath.Dir(s.dir)
+		if s.dir == "." {
+			s.dir = ""
+		}
+	}
+	s.focus = nil
+	s.scroll = 0
+}
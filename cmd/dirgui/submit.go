@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var submitMode = flag.Bool("submit", false, "Whether clicking an executable first saves every edited field in its form, all or nothing, and reloads the fields after it runs")
+
+// dirty reports whether widget's field has been edited since it was last loaded or saved.
+func (widget *Widget) dirty() bool {
+	return widget.content != widget.diskContent
+}
+
+// A fieldSave is an edited field for saveFields to save, copied while formsLock is held so that it can be saved without it.
+type fieldSave struct {
+	widget      *Widget
+	label       string
+	name        string
+	path        string
+	target      string // the file path refers to, if it's a symlink
+	content     string
+	fileContent string
+	problem     string // with the field's rules, if any
+	validator   string
+	since       *fileVersion // as of the field's last Load or Save
+
+	// set by saveFields
+	original []byte
+	existed  bool
+	backup   string // made by saving the field, if -backup made a new one
+	version  *fileVersion
+	conflict *conflict
+}
+
+// editedFields returns the edited fields in widgets, for saveFields. formsLock must be held.
+func editedFields(widgets []*Widget) []*fieldSave {
+	var saves []*fieldSave
+	for _, widget := range widgets {
+		if widget.isField() && widget.dirty() {
+			saves = append(saves, &fieldSave{
+				widget:      widget,
+				label:       widget.label(),
+				name:        widget.fileInfo.Name(),
+				path:        widget.path(),
+				content:     widget.content,
+				fileContent: widget.fileContent(),
+				problem:     widget.checkRules(),
+				validator:   widget.validator,
+				since:       widget.version,
+			})
+		}
+	}
+	return saves
+}
+
+// saveFields writes every field in saves to its file, unless any of them are invalid or their files changed since they were loaded. If any can't be written, the ones that were are restored and the backups made of them are removed, so that either all of them are saved or none are. It waits for validators and disk I/O, so formsLock shouldn't be held; recordSaves records the results once it is.
+func saveFields(saves []*fieldSave) error {
+	var written, attempted []*fieldSave
+	rollback := func() {
+		for _, sv := range written {
+			var err error
+			if sv.existed {
+				_, err = writeFile(sv.target, sv.original, false)
+			} else {
+				err = os.Remove(sv.target)
+			}
+			if err != nil {
+				log.Printf("couldn't restore %q: %v", sv.target, err)
+			}
+		}
+		for _, sv := range attempted {
+			if sv.backup == "" {
+				continue
+			}
+			if err := os.Remove(sv.backup); err != nil {
+				log.Printf("couldn't remove backup %q: %v", sv.backup, err)
+			}
+		}
+	}
+
+	for _, sv := range saves {
+		problem := sv.problem
+		if problem == "" && sv.validator != "" {
+			problem = sv.widget.runValidator(sv.validator, sv.name, sv.fileContent)
+		}
+		if problem != "" {
+			return fmt.Errorf("%s: %s", sv.label, problem)
+		}
+	}
+
+	for _, sv := range saves {
+		var err error
+		if sv.target, err = resolveSymlinks(sv.path); err != nil {
+			rollback()
+			return fmt.Errorf("couldn't resolve %q: %v", sv.path, err)
+		}
+		original, existed, c, err := checkDisk(sv.path, sv.fileContent, sv.since)
+		if err != nil {
+			sv.conflict = c
+			rollback()
+			return err
+		}
+		attempted = append(attempted, sv)
+		if sv.backup, err = writeFile(sv.target, []byte(sv.fileContent), true); err != nil {
+			rollback()
+			return fmt.Errorf("couldn't write %q: %v", sv.path, err)
+		}
+		sv.original, sv.existed = original, existed
+		written = append(written, sv)
+	}
+
+	for _, sv := range saves {
+		sv.version = savedVersion(sv.path, sv.fileContent)
+	}
+	return nil
+}
+
+// recordSaves records what saveFields did, given the error it returned, in the fields it saved. formsLock must be held.
+func recordSaves(saves []*fieldSave, err error) {
+	for _, sv := range saves {
+		if sv.conflict != nil {
+			sv.conflict.loaded = sv.widget.loaded
+			sv.widget.conflict = sv.conflict
+		}
+		if err == nil {
+			sv.widget.saved(sv.content, sv.version)
+		}
+	}
+}
+
+// reloadFields loads the fields in widgets again, except for those with edits that would be lost.
+func reloadFields(widgets []*Widget) {
+	for _, widget := range widgets {
+		if widget.isField() && widget.loaded && !widget.dirty() && !widget.loading && !widget.saving {
+			widget.stale = false
+			widget.load()
+		}
+	}
+}
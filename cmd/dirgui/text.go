@@ -0,0 +1,506 @@
+package main
+
+import (
+	"github.com/alltom/dirgui/rfb"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EditorState is the state of a text field or text area. Offsets are in bytes.
+type EditorState struct {
+	caret    int
+	anchor   int  // other end of the selection from the caret; equal to caret if nothing is selected
+	dragging bool // selecting with the pointer
+	scroll   int  // first visible line in text areas, or pixels scrolled horizontally in text fields
+
+	readOnly bool // text can be selected and copied, but not changed
+	tail     bool // text area stays scrolled to the end as text is added, unless it's scrolled away
+	atEnd    bool // text area was scrolled to the end
+}
+
+// textAreaRows is the number of lines of text that are visible in a text area.
+const textAreaRows = 8
+
+var selectionColor = color.NRGBA{0xd6, 0xc2, 0xff, 0xff}
+
+// edit is a single-line text field that scrolls horizontally to keep the caret in view.
+func edit(s *session, state *EditorState, text *string, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	draw.Draw(img, rect, image.NewUniform(color.Black), image.ZP, draw.Src)
+	draw.Draw(img, rect.Inset(1), image.NewUniform(color.White), image.ZP, draw.Src)
+
+	face := s.face()
+	layout := func(text string) *textLayout { return singleLineLayout(face, text) }
+	state.clamp(*text)
+
+	focused := s.focusable(state, rect)
+	follow := false
+	if focused && keyEvent != nil {
+		typeKey(s, state, text, keyEvent, layout, false)
+		follow = true
+	}
+	l := layout(*text)
+	if pointerIn(rect, &s.pointerEvent) {
+		s.cursor = ibeamCursor
+	}
+	if state.drag(s, rect, func(pt image.Point) int {
+		return l.offsetAt(0, pt.X-(rect.Min.X+8)+state.scroll)
+	}) {
+		follow = true
+	}
+
+	if follow {
+		visible := rect.Dx() - 16
+		caretX := l.x(state.caret)
+		if caretX-state.scroll > visible {
+			state.scroll = caretX - visible
+		}
+		if caretX < state.scroll {
+			state.scroll = caretX
+		}
+	}
+	if state.scroll < 0 {
+		state.scroll = 0
+	}
+
+	clipRect := image.Rect(rect.Min.X+4, rect.Min.Y+1, rect.Max.X-4, rect.Max.Y-1)
+	drawText(clip{img, clipRect}, image.Pt(rect.Min.X+8-state.scroll, rect.Min.Y+(rect.Dy()-lineHeight)/2), l, 0, 1, state, focused)
+	if focused {
+		focusRing(rect, img)
+	}
+}
+
+// textArea is a multi-line version of edit that wraps long lines and scrolls vertically.
+func textArea(s *session, state *EditorState, text *string, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) {
+	draw.Draw(img, rect, image.NewUniform(color.Black), image.ZP, draw.Src)
+	draw.Draw(img, rect.Inset(1), image.NewUniform(color.White), image.ZP, draw.Src)
+
+	face := s.face()
+	layout := func(text string) *textLayout { return wrapLayout(face, text, rect.Dx()-16) }
+	state.clamp(*text)
+
+	focused := s.focusable(state, rect)
+	follow, scrolled := false, false
+	if focused && keyEvent != nil {
+		typeKey(s, state, text, keyEvent, layout, true)
+		follow = true
+	}
+	l := layout(*text)
+	if pointerIn(rect, &s.pointerEvent) {
+		s.cursor = ibeamCursor
+		if len(l.lines) > textAreaRows {
+			if s.pressed&(1<<3) != 0 { // wheel up
+				state.scroll -= 3
+				scrolled = true
+			}
+			if s.pressed&(1<<4) != 0 { // wheel down
+				state.scroll += 3
+				scrolled = true
+			}
+			s.pressed &^= 1<<3 | 1<<4 // so the form doesn't scroll too
+		}
+	}
+	if state.drag(s, rect, func(pt image.Point) int {
+		line := state.scroll + (pt.Y-(rect.Min.Y+4))/lineHeight
+		if line < 0 {
+			return 0
+		} else if line >= len(l.lines) {
+			return len(*text)
+		}
+		return l.offsetAt(line, pt.X-(rect.Min.X+8))
+	}) {
+		follow = true
+	}
+
+	if follow {
+		caretLine := l.lineOf(state.caret)
+		if caretLine < state.scroll {
+			state.scroll = caretLine
+		}
+		if caretLine >= state.scroll+textAreaRows {
+			state.scroll = caretLine - textAreaRows + 1
+		}
+	}
+	maxScroll := len(l.lines) - textAreaRows
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if state.tail && state.atEnd && !follow && !scrolled {
+		state.scroll = maxScroll
+	}
+	if state.scroll > maxScroll {
+		state.scroll = maxScroll
+	}
+	if state.scroll < 0 {
+		state.scroll = 0
+	}
+	state.atEnd = state.scroll == maxScroll
+
+	drawText(clip{img, rect.Inset(1)}, image.Pt(rect.Min.X+8, rect.Min.Y+4), l, state.scroll, textAreaRows, state, focused)
+
+	if maxScroll > 0 { // scroll bar
+		track := image.Rect(rect.Max.X-5, rect.Min.Y+2, rect.Max.X-2, rect.Max.Y-2)
+		thumbHeight := track.Dy() * textAreaRows / len(l.lines)
+		thumbTop := track.Min.Y + (track.Dy()-thumbHeight)*state.scroll/maxScroll
+		draw.Draw(img, image.Rect(track.Min.X, thumbTop, track.Max.X, thumbTop+thumbHeight), image.NewUniform(primaryLightColor), image.ZP, draw.Src)
+	}
+	if focused {
+		focusRing(rect, img)
+	}
+}
+
+// drawText draws count lines of l starting with line first, with the top left of the first at origin, along with the selection and (if focused) the caret.
+func drawText(img draw.Image, origin image.Point, l *textLayout, first, count int, state *EditorState, focused bool) {
+	selStart, selEnd := state.selection()
+	fd := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: l.face,
+	}
+	for i := 0; i < count && first+i < len(l.lines); i++ {
+		line := l.lines[first+i]
+		top := origin.Y + i*lineHeight
+
+		if selStart < selEnd && selStart <= line.end && selEnd >= line.start {
+			from, to := selStart, selEnd
+			if from < line.start {
+				from = line.start
+			}
+			right := origin.X + 4 // show selected newlines
+			if to <= line.end {
+				right = origin.X + l.x(to)
+			} else {
+				right += l.x(line.end)
+			}
+			draw.Draw(img, image.Rect(origin.X+l.x(from), top, right, top+lineHeight), image.NewUniform(selectionColor), image.ZP, draw.Src)
+		}
+
+		fd.Dot = fixed.Point26_6{X: fixed.I(origin.X), Y: fixed.I(top + baseline)}
+		fd.DrawString(l.text[line.start:line.end])
+
+		if focused && l.lineOf(state.caret) == first+i {
+			x := origin.X + l.x(state.caret)
+			draw.Draw(img, image.Rect(x, top+1, x+1, top+lineHeight-1), image.NewUniform(color.Black), image.ZP, draw.Src)
+		}
+	}
+}
+
+// drag places the caret where the pointer is pressed in rect and extends the selection as it's dragged, returning whether the caret moved. offsetAt maps points to offsets.
+func (state *EditorState) drag(s *session, rect image.Rectangle, offsetAt func(image.Point) int) bool {
+	pt := image.Pt(int(s.pointerEvent.X), int(s.pointerEvent.Y))
+	if s.pressed&1 != 0 && pt.In(rect) {
+		state.dragging = true
+		state.caret = offsetAt(pt)
+		if !s.shift() {
+			state.anchor = state.caret
+		}
+		return true
+	}
+	if !state.dragging {
+		return false
+	}
+	if s.pointerEvent.ButtonMask&1 == 0 {
+		state.dragging = false
+		return false
+	}
+	caret := offsetAt(pt)
+	moved := caret != state.caret
+	state.caret = caret
+	return moved
+}
+
+// selection returns the selected range of bytes, which is empty if nothing is selected.
+func (state *EditorState) selection() (int, int) {
+	if state.anchor < state.caret {
+		return state.anchor, state.caret
+	}
+	return state.caret, state.anchor
+}
+
+// clamp keeps the caret and selection within text, which may have been replaced since they were set.
+func (state *EditorState) clamp(text string) {
+	for _, offset := range []*int{&state.caret, &state.anchor} {
+		if *offset > len(text) {
+			*offset = len(text)
+		}
+		for *offset > 0 && *offset < len(text) && !utf8.RuneStart(text[*offset]) {
+			*offset--
+		}
+	}
+}
+
+// typeKey applies keyEvent to the text being edited, returning whether the text changed. layout arranges text the way it's displayed, for moving between lines. Newlines are only typed if multiline is true.
+func typeKey(s *session, state *EditorState, text *string, keyEvent *rfb.KeyEvent, layout func(string) *textLayout, multiline bool) bool {
+	if !keyEvent.Pressed {
+		return false
+	}
+
+	selStart, selEnd := state.selection()
+	move := func(offset int) {
+		state.caret = offset
+		if !s.shift() {
+			state.anchor = offset
+		}
+	}
+	replace := func(start, end int, with string) {
+		if state.readOnly {
+			return
+		}
+		*text = (*text)[:start] + with + (*text)[end:]
+		state.caret = start + len(with)
+		state.anchor = state.caret
+	}
+
+	switch keyEvent.KeySym {
+	case 0xff51, 0xff96: // Left, KP_Left
+		if selStart < selEnd && !s.shift() {
+			move(selStart)
+		} else if s.ctrl() {
+			move(prevWord(*text, state.caret))
+		} else {
+			move(prevRune(*text, state.caret))
+		}
+	case 0xff53, 0xff98: // Right, KP_Right
+		if selStart < selEnd && !s.shift() {
+			move(selEnd)
+		} else if s.ctrl() {
+			move(nextWord(*text, state.caret))
+		} else {
+			move(nextRune(*text, state.caret))
+		}
+	case 0xff52, 0xff97, 0xff54, 0xff99: // Up, KP_Up, Down, KP_Down
+		if !multiline {
+			return false
+		}
+		l := layout(*text)
+		line := l.lineOf(state.caret)
+		if keyEvent.KeySym == 0xff52 || keyEvent.KeySym == 0xff97 {
+			line--
+		} else {
+			line++
+		}
+		if line < 0 {
+			move(0)
+		} else if line >= len(l.lines) {
+			move(len(*text))
+		} else {
+			move(l.offsetAt(line, l.x(state.caret)))
+		}
+	case 0xff50, 0xff95: // Home, KP_Home
+		if s.ctrl() {
+			move(0)
+		} else {
+			l := layout(*text)
+			move(l.lines[l.lineOf(state.caret)].start)
+		}
+	case 0xff57, 0xff9c: // End, KP_End
+		if s.ctrl() {
+			move(len(*text))
+		} else {
+			l := layout(*text)
+			move(l.lines[l.lineOf(state.caret)].end)
+		}
+	case 0xff08: // BackSpace
+		if selStart < selEnd {
+			replace(selStart, selEnd, "")
+		} else if s.ctrl() {
+			replace(prevWord(*text, state.caret), state.caret, "")
+		} else {
+			replace(prevRune(*text, state.caret), state.caret, "")
+		}
+		return !state.readOnly
+	case 0xffff, 0xff9f: // Delete, KP_Delete
+		if selStart < selEnd {
+			replace(selStart, selEnd, "")
+		} else if s.ctrl() {
+			replace(state.caret, nextWord(*text, state.caret), "")
+		} else {
+			replace(state.caret, nextRune(*text, state.caret), "")
+		}
+		return !state.readOnly
+	case 0xff0d, 0xff8d: // Return, KP_Enter
+		if !multiline {
+			return false
+		}
+		replace(selStart, selEnd, "\n")
+		return !state.readOnly
+	default:
+		r, ok := rfb.KeySymRune(keyEvent.KeySym)
+		if !ok {
+			return false
+		}
+		if s.ctrl() {
+			switch r {
+			case 'a', 'A':
+				state.anchor = 0
+				state.caret = len(*text)
+			case 'c', 'C':
+				if selStart < selEnd {
+					s.cutText = (*text)[selStart:selEnd]
+				}
+			}
+			return false
+		}
+		if s.modifiers&(rfb.Meta|rfb.Super) != 0 {
+			return false // shortcuts for the client
+		}
+		replace(selStart, selEnd, string(r))
+		return !state.readOnly
+	}
+	return false
+}
+
+func prevRune(text string, offset int) int {
+	_, size := utf8.DecodeLastRuneInString(text[:offset])
+	return offset - size
+}
+
+func nextRune(text string, offset int) int {
+	_, size := utf8.DecodeRuneInString(text[offset:])
+	return offset + size
+}
+
+// prevWord returns the offset of the start of the word before offset.
+func prevWord(text string, offset int) int {
+	for offset > 0 && !isWordRune(text, prevRune(text, offset)) {
+		offset = prevRune(text, offset)
+	}
+	for offset > 0 && isWordRune(text, prevRune(text, offset)) {
+		offset = prevRune(text, offset)
+	}
+	return offset
+}
+
+// nextWord returns the offset of the start of the word after offset.
+func nextWord(text string, offset int) int {
+	for offset < len(text) && isWordRune(text, offset) {
+		offset = nextRune(text, offset)
+	}
+	for offset < len(text) && !isWordRune(text, offset) {
+		offset = nextRune(text, offset)
+	}
+	return offset
+}
+
+func isWordRune(text string, offset int) bool {
+	r, _ := utf8.DecodeRuneInString(text[offset:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// lineSpan is a range of bytes shown on one line, not including any newline that ends it.
+type lineSpan struct {
+	start, end int
+}
+
+// textLayout maps between offsets in text and positions on the screen.
+type textLayout struct {
+	face  font.Face
+	text  string
+	lines []lineSpan
+}
+
+func singleLineLayout(face font.Face, text string) *textLayout {
+	return &textLayout{face: face, text: text, lines: []lineSpan{{0, len(text)}}}
+}
+
+// wrapLayout breaks text into lines no wider than width pixels, breaking after spaces where possible.
+func wrapLayout(face font.Face, text string, width int) *textLayout {
+	l := &textLayout{face: face, text: text}
+	for start := 0; ; {
+		end := len(text)
+		if nl := strings.IndexByte(text[start:], '\n'); nl >= 0 {
+			end = start + nl
+		}
+		for lineStart := start; ; {
+			lineEnd := l.fit(lineStart, end, width)
+			l.lines = append(l.lines, lineSpan{lineStart, lineEnd})
+			if lineEnd >= end {
+				break
+			}
+			lineStart = lineEnd
+		}
+		if end == len(text) {
+			return l
+		}
+		start = end + 1
+	}
+}
+
+// fit returns the offset at which to break text[start:end] so that the first part is no wider than width pixels.
+func (l *textLayout) fit(start, end, width int) int {
+	var x fixed.Int26_6
+	afterSpace := -1
+	prev := rune(-1)
+	for i, r := range l.text[start:end] {
+		if prev >= 0 {
+			x += l.face.Kern(prev, r)
+		}
+		prev = r
+		advance, _ := l.face.GlyphAdvance(r)
+		if (x+advance).Ceil() > width && i > 0 {
+			if afterSpace > 0 {
+				return start + afterSpace
+			}
+			return start + i
+		}
+		x += advance
+		if r == ' ' {
+			afterSpace = i + 1
+		}
+	}
+	return end
+}
+
+// lineOf returns the index of the line containing offset. An offset between two wrapped lines is on the second one.
+func (l *textLayout) lineOf(offset int) int {
+	line := 0
+	for i, span := range l.lines {
+		if span.start <= offset {
+			line = i
+		}
+	}
+	return line
+}
+
+// x returns the horizontal position of offset relative to the start of its line.
+func (l *textLayout) x(offset int) int {
+	span := l.lines[l.lineOf(offset)]
+	if offset > span.end {
+		offset = span.end
+	}
+	return font.MeasureString(l.face, l.text[span.start:offset]).Round()
+}
+
+// offsetAt returns the offset on the given line closest to horizontal position x.
+func (l *textLayout) offsetAt(line, x int) int {
+	span := l.lines[line]
+	var pos fixed.Int26_6
+	prev := rune(-1)
+	for i, r := range l.text[span.start:span.end] {
+		if prev >= 0 {
+			pos += l.face.Kern(prev, r)
+		}
+		prev = r
+		advance, _ := l.face.GlyphAdvance(r)
+		if fixed.I(x) < pos+advance/2 {
+			return span.start + i
+		}
+		pos += advance
+	}
+	return span.end
+}
+
+// clip restricts drawing on an image to a rectangle.
+type clip struct {
+	draw.Image
+	rect image.Rectangle
+}
+
+func (c clip) Bounds() image.Rectangle {
+	return c.rect.Intersect(c.Image.Bounds())
+}
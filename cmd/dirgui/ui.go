@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"encoding/binary"
 	"flag"
 	"fmt"
 	"github.com/alltom/dirgui/rfb"
 	"golang.org/x/image/font"
-	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 	"image"
 	"image/color"
@@ -21,6 +19,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 const windowWidth = 40 * 8
@@ -28,42 +27,88 @@ const windowWidth = 40 * 8
 var (
 	primaryColor      = color.NRGBA{0x60, 0x02, 0xee, 0xff}
 	primaryLightColor = color.NRGBA{0x99, 0x46, 0xff, 0xff}
+	helpColor         = color.NRGBA{0x61, 0x61, 0x61, 0xff}
+	disabledColor     = color.NRGBA{0xbd, 0xbd, 0xbd, 0xff}
 )
 
 type Widget struct {
 	fileInfo os.FileInfo
+	dir      string           // containing directory
+	settings fileSettings     // from the directory's manifest
+	section  *manifestSection // that the widget is shown in, if any
 
 	// files
-	content string
-	editor  EditorState
-	loading bool
-	saving  bool
+	content         string
+	editor          EditorState
+	multiline       bool // edited in a text area rather than a single-line field
+	trailingNewline bool // single-line file ends in a newline that isn't shown in the field
+	loading         bool
+	saving          bool
+	loaded          bool         // content has been loaded or saved
+	diskContent     string       // content as of the last Load or Save, for telling whether it's been edited
+	version         *fileVersion // of the file as of the last Load or Save, for telling whether it changed, or nil if neither has happened
+	conflict        *conflict    // found by the last Save, if any
+	stale           bool         // loaded file changed on disk
+	kind            fieldKind
+	options         []string // for dropdowns
+	min, max, step  float64  // for numbers, where step is 0 for the default
+	hasRange        bool     // min and max are set
+	showOptions     bool     // dropdown is open
+	optionButtons   []ButtonState
+	validator       string // run to check values before they're saved, or ""
+	validationLock  sync.Mutex
+	validating      bool   // validator is checking a value
+	validated       bool   // validator has checked checkedValue
+	checkedValue    string // last value the validator checked, as it would be saved
+	verdict         string // validator's objection to checkedValue, or "" if it had none
 
 	// executables
-	running bool
+	running     bool
+	runs        []*run      // oldest first
+	shownRun    *run        // run whose output is shown, or nil for the latest
+	showOutput  bool        // output is expanded
+	outputView  EditorState // for the output, which is read-only
+	showHistory bool
+
+	// images
+	image image.Image // scaled to fit the form
 
 	// files with guis
+	guiCmd     *exec.Cmd
+	guiFailed  bool // the editor couldn't be started, so it isn't tried again until the widget is replaced
 	guiSize    image.Point
 	lastGuiImg image.Image
+	guiCursor  *rfb.Cursor // nil until the editor sends one
 	guiLock    sync.Mutex
+	guiEvents  chan interface{} // input for the editor
+	guiPointer rfb.PointerEvent // last pointer event sent to the editor
 
 	button1 ButtonState // read for files, run for executables
-	button2 ButtonState // save for files
+	button2 ButtonState // save for files, show or hide output for executables
+	button3 ButtonState // copy output for executables, or the checkbox, slider, dropdown, or spin box's decrement button for fields
+	button4 ButtonState // show or hide history for executables, or the spin box's increment button for fields
+	button5 ButtonState // stop for executables
+
+	overwriteButton ButtonState
+	reloadButton    ButtonState
+	diffButton      ButtonState
 }
 
 type ButtonState struct {
 	clicking bool
 }
 
-type EditorState struct {
-	lastKeySym uint32
-}
-
+// wdir is the directory whose form is shown first. Subdirectories are shown by navigating into them.
 var wdir string
-var widgets []*Widget
 var once sync.Once
 
-func getWidgets() {
+// forms caches the widgets for each directory that's been visited, by path relative to wdir, so that their state is shared by all sessions. They're kept up to date by watchForm.
+var forms = make(map[string][]*Widget)
+
+// formsLock guards forms and their widgets, which watchForm and background loads, saves, and runs update while updateUI draws them.
+var formsLock sync.Mutex
+
+func getWdir() {
 	switch flag.NArg() {
 	case 0:
 		wdir = "."
@@ -72,139 +117,459 @@ func getWidgets() {
 	default:
 		log.Fatalf("Expected 0 or 1 arguments, but found %d", flag.NArg())
 	}
+}
+
+// getForm returns the widgets for dir (relative to wdir), creating them on first use. formsLock must be held, but it's released while they're created, since that can take a while.
+func getForm(dir string) ([]*Widget, error) {
+	if widgets, ok := forms[dir]; ok {
+		return widgets, nil
+	}
+	formsLock.Unlock()
+	u, err := getWidgets(filepath.Join(wdir, dir), nil)
+	formsLock.Lock()
+	if err != nil {
+		return nil, err
+	}
+	if widgets, ok := forms[dir]; ok {
+		// Another session created them in the meantime.
+		for _, widget := range u.widgets {
+			widget.stop()
+		}
+		return widgets, nil
+	}
+	forms[dir] = u.apply()
+	go watchForm(dir)
+	return forms[dir], nil
+}
+
+// refreshForm updates the widgets for dir (relative to wdir) to match the files in it. It only holds formsLock while it copies the widgets' state and swaps in the changes.
+func refreshForm(dir string) error {
+	formsLock.Lock()
+	var old []widgetState
+	for _, widget := range forms[dir] {
+		old = append(old, widgetState{widget, widget.loaded, widget.multiline})
+	}
+	formsLock.Unlock()
+
+	u, err := getWidgets(filepath.Join(wdir, dir), old)
+	formsLock.Lock()
+	defer formsLock.Unlock()
+	if err != nil {
+		for _, st := range old {
+			st.widget.stop()
+		}
+		delete(forms, dir)
+		return err
+	}
+	forms[dir] = u.apply()
+	return nil
+}
+
+// A widgetState is what getWidgets needs to know about a widget it might reuse, copied while formsLock is held.
+type widgetState struct {
+	widget    *Widget
+	loaded    bool
+	multiline bool
+}
+
+// A formUpdate is what getWidgets found in a directory: its widgets, in directory order, with the changes to make to the reused ones and the old ones to stop.
+type formUpdate struct {
+	manifest *manifest
+	widgets  []*Widget
+	changes  []widgetChange
+	removed  []*Widget
+}
+
+// apply makes u's changes, stops its removed widgets, and returns its widgets in the order they're shown. formsLock must be held.
+func (u *formUpdate) apply() []*Widget {
+	for _, c := range u.changes {
+		c.apply()
+	}
+	for _, widget := range u.removed {
+		widget.stop()
+	}
+	return u.manifest.arrange(u.widgets)
+}
 
-	infos, err := ioutil.ReadDir(wdir)
+// getWidgets works out the widgets for the files in dir without holding formsLock, since it reads files and starts custom editors. Widgets in old that still suit their files are reused, and the others are removed. New widgets are set up right away, since no one else can see them yet.
+func getWidgets(dir string, old []widgetState) (*formUpdate, error) {
+	infos, err := ioutil.ReadDir(dir)
 	if err != nil {
-		log.Fatalf("couldn't read directory %q: %v", wdir, err)
+		return nil, fmt.Errorf("couldn't read directory %q: %v", dir, err)
+	}
+	for i, info := range infos {
+		// Widgets are for what symlinks point to, unless they're dangling.
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(dir, info.Name())); err == nil {
+				infos[i] = target
+			}
+		}
 	}
 
+	names := make(map[string]bool)
 	for _, info := range infos {
-		if info.IsDir() {
+		names[info.Name()] = true
+	}
+	handlers := loadHandlers(dir)
+	m := loadManifest(dir)
+	previous := make(map[string]widgetState)
+	for _, st := range old {
+		previous[st.widget.fileInfo.Name()] = st
+	}
+
+	u := &formUpdate{manifest: m}
+	for _, info := range infos {
+		settings := m.Files[info.Name()]
+		if strings.HasPrefix(info.Name(), ".") || settings.Hidden {
 			continue
 		}
-		if strings.HasPrefix(info.Name(), ".") {
+		if strings.HasSuffix(info.Name(), ".gui") && names[strings.TrimSuffix(info.Name(), ".gui")] {
+			continue // custom editor for a sibling file
+		}
+		if strings.HasSuffix(info.Name(), timeoutSuffix) && names[strings.TrimSuffix(info.Name(), timeoutSuffix)] {
+			continue // timeout for a sibling executable
+		}
+		if ext := filepath.Ext(info.Name()); (ext == optionsSuffix || ext == rangeSuffix || ext == validatorSuffix) && names[strings.TrimSuffix(info.Name(), ext)] {
+			continue // configures a sibling field
+		}
+		if m := backupPattern.FindStringSubmatch(info.Name()); m != nil && names[m[1]] {
+			continue // backup of a sibling file
+		}
+
+		var editor *handler
+		if info.IsDir() || settings.forcesField() {
+			// navigated to, or edited as text
+		} else if names[info.Name()+".gui"] {
+			editor = &handler{command: info.Name() + ".gui"}
+		} else if !isExecutable(info) {
+			editor = findHandler(handlers, dir, info.Name())
+		}
+
+		if st, ok := previous[info.Name()]; ok && st.widget.suits(info, settings, editor != nil) {
+			delete(previous, info.Name())
+			u.changes = append(u.changes, st.refreshed(info, settings))
+			u.widgets = append(u.widgets, st.widget)
 			continue
 		}
-		if len(widgets) > 0 && info.Name() == (widgets[len(widgets)-1].fileInfo.Name()+".gui") {
-			widget := widgets[len(widgets)-1]
 
-			cmd := &exec.Cmd{
-				Path:   info.Name(),
-				Args:   []string{info.Name(), widget.fileInfo.Name()},
-				Dir:    wdir,
-				Stdout: os.Stdout,
-				Stderr: os.Stderr,
+		widget := &Widget{fileInfo: info, dir: dir, settings: settings}
+		u.widgets = append(u.widgets, widget)
+		if info.IsDir() {
+			continue
+		}
+		widget.multiline = settings.Type == "textarea" || isMultiline(widget.path())
+		if editor != nil {
+			if err := startEditor(widget, editor); err != nil {
+				log.Printf("couldn't launch editor for %q: %v", info.Name(), err)
+				widget.guiFailed = true
 			}
+		} else if !widget.isButton() && !settings.forcesField() {
+			widget.image = loadImage(widget.path(), windowWidth-16)
+		}
+		if widget.isField() {
+			widget.configure(readFieldConfig(widget.path(), info, settings, widget.multiline))
+		}
+	}
 
-			imgs := make(chan image.Image)
-			bounds, err := nestRfb(cmd, imgs)
-			if err != nil {
-				log.Fatalf("couldn't launch nested rfb: %v", err)
-			}
-			widget.guiSize = bounds.Max
-			widget.lastGuiImg = image.NewRGBA(image.Rect(0, 0, widget.guiSize.X, widget.guiSize.Y))
-
-			go func(widget *Widget, imgs chan image.Image) {
-				for img := range imgs {
-					widget.guiLock.Lock()
-					widget.lastGuiImg = img
-					widget.guiLock.Unlock()
-				}
-			}(widget, imgs)
+	for _, st := range previous {
+		u.removed = append(u.removed, st.widget)
+	}
+	return u, nil
+}
 
-			continue
+// suits reports whether widget is the right kind of widget for a file described by info and settings, given whether it has a custom editor. A widget whose editor couldn't be started still suits, so that it isn't started again on every change to the directory.
+func (widget *Widget) suits(info os.FileInfo, settings fileSettings, hasEditor bool) bool {
+	return widget.fileInfo.IsDir() == info.IsDir() &&
+		isExecutable(widget.fileInfo) == isExecutable(info) &&
+		widget.settings.Type == settings.Type &&
+		(widget.guiCmd != nil || widget.guiFailed) == hasEditor
+}
+
+// A widgetChange updates a reused widget for its file, described by info and settings.
+type widgetChange struct {
+	widget    *Widget
+	info      os.FileInfo
+	settings  fileSettings
+	changed   bool // the file changed on disk, so a loaded field is stale, or else its image and multiline were read again
+	image     image.Image
+	multiline bool
+	config    *fieldConfig // for fields
+}
+
+// refreshed works out how st's widget changes for its file, described by info and settings. It reads the file if it changed, but doesn't touch the widget, so formsLock needn't be held.
+func (st widgetState) refreshed(info os.FileInfo, settings fileSettings) widgetChange {
+	widget := st.widget
+	c := widgetChange{widget: widget, info: info, settings: settings, image: widget.image, multiline: st.multiline}
+	changed := info.Size() != widget.fileInfo.Size() || !info.ModTime().Equal(widget.fileInfo.ModTime())
+	if changed && !info.IsDir() && !widget.isButton() && widget.guiCmd == nil {
+		c.changed = true
+		if !settings.forcesField() {
+			c.image = loadImage(widget.path(), windowWidth-16)
+		}
+		if !st.loaded {
+			c.multiline = settings.Type == "textarea" || isMultiline(widget.path())
+		}
+	}
+	if !info.IsDir() && !widget.isButton() && widget.guiSize == image.ZP && c.image == nil {
+		config := readFieldConfig(widget.path(), info, settings, c.multiline)
+		c.config = &config
+	}
+	return c
+}
+
+// apply updates c's widget. Fields whose files changed are marked stale so that updateUI reloads them when it's safe. formsLock must be held.
+func (c widgetChange) apply() {
+	widget := c.widget
+	widget.fileInfo = c.info
+	widget.settings = c.settings
+	if c.changed {
+		widget.image = c.image
+		if widget.loaded {
+			widget.stale = true
+		} else {
+			widget.multiline = c.multiline
 		}
-		widgets = append(widgets, &Widget{fileInfo: info})
+	}
+	if c.config != nil {
+		widget.configure(*c.config)
 	}
 }
 
-func updateUI(img draw.Image, keyEvent *rfb.KeyEvent, pointerEvent *rfb.PointerEvent) image.Rectangle {
-	once.Do(getWidgets)
+// stop kills widget's custom editor, if it has one, and closes its input, which ends the goroutines that talk to it. formsLock must be held, and widget must no longer be in forms, so that no session sends it input.
+func (widget *Widget) stop() {
+	if widget.guiCmd != nil {
+		widget.guiCmd.Process.Kill()
+		close(widget.guiEvents)
+	}
+}
+
+// isField reports whether widget is a text field or text area.
+func (widget *Widget) isField() bool {
+	return !widget.fileInfo.IsDir() && !widget.isButton() && widget.guiSize == image.ZP && widget.image == nil
+}
+
+// isButton reports whether widget is a button that runs its file.
+func (widget *Widget) isButton() bool {
+	return !widget.fileInfo.IsDir() && isExecutable(widget.fileInfo) && !widget.settings.forcesField()
+}
+
+func (widget *Widget) path() string {
+	return filepath.Join(widget.dir, widget.fileInfo.Name())
+}
+
+// isExecutable reports whether info describes an executable file. Dangling symlinks aren't, even though their permissions say they are.
+func isExecutable(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink == 0 && info.Mode().Perm()&0111 != 0
+}
+
+// startEditor runs the custom editor (with a path relative to the file's directory) for widget's file and displays its GUI in place of the usual widgets.
+func startEditor(widget *Widget, editor *handler) error {
+	cmd := &exec.Cmd{
+		Path:   editor.command,
+		Args:   []string{editor.command, widget.fileInfo.Name()},
+		Dir:    widget.dir,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	imgs := make(chan image.Image)
+	cursors := make(chan *rfb.Cursor)
+	widget.guiEvents = make(chan interface{}, 16)
+	var bounds image.Rectangle
+	var err error
+	if editor.frames {
+		bounds, err = nestFrames(cmd, imgs, widget.guiEvents)
+	} else {
+		bounds, err = nestRfb(cmd, imgs, cursors, widget.guiEvents)
+	}
+	if err != nil {
+		close(widget.guiEvents)
+		widget.guiEvents = nil
+		return err
+	}
+	widget.guiCmd = cmd
+	widget.guiSize = bounds.Max
+	widget.lastGuiImg = image.NewRGBA(image.Rect(0, 0, widget.guiSize.X, widget.guiSize.Y))
+
+	go func(widget *Widget, imgs chan image.Image, cursors chan *rfb.Cursor) {
+		for {
+			select {
+			case img, ok := <-imgs:
+				if !ok {
+					return // the editor exited or was stopped
+				}
+				widget.guiLock.Lock()
+				widget.lastGuiImg = img
+				widget.guiLock.Unlock()
+			case cursor, ok := <-cursors:
+				if !ok {
+					cursors = nil
+					continue
+				}
+				widget.guiLock.Lock()
+				widget.guiCursor = cursor
+				widget.guiLock.Unlock()
+			}
+		}
+	}(widget, imgs, cursors)
+
+	return nil
+}
 
-	var y = 8 // top padding
+// updateUI draws the form into img and handles input for s, returning the bounds of the visible part of the form (see viewport). keyEvent is nil unless a key was just pressed or released.
+func updateUI(s *session, img draw.Image, keyEvent *rfb.KeyEvent) image.Rectangle {
+	once.Do(getWdir)
+
+	keyEvent = s.beginUpdate(keyEvent)
+	pointerEvent := &s.pointerEvent
+	face := s.face()
+	buttonHeight := lineHeight + 8
+	var y = 8 - s.scroll // top padding
+
+	formsLock.Lock()
+	defer formsLock.Unlock()
+	widgets, err := getForm(s.dir)
+	for err != nil && s.dir != "" {
+		log.Printf("couldn't load form: %v", err)
+		s.back()
+		widgets, err = getForm(s.dir)
+	}
 
 	// background color
 	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
 
+	if err != nil {
+		// getForm tries again on the next update, in case wdir can be read again by then.
+		y = paragraph(s, fmt.Sprintf("Couldn't load form: %v", err), failureColor, y, img)
+		return viewport(s, y+s.scroll, img, keyEvent)
+	}
+
+	if s.dir != "" {
+		if keyEvent != nil && keyEvent.Pressed && keyEvent.KeySym == 0xff51 && s.modifiers&rfb.Alt != 0 { // Alt+Left
+			s.back()
+			keyEvent = nil
+		}
+		y = breadcrumbs(s, y, img, keyEvent) + 8
+	}
+
+	var section *manifestSection
 	for idx, widget := range widgets {
-		if widget.guiSize != image.ZP { // has a remote GUI
-			label(widget.fileInfo.Name(), image.Rect(8, y, windowWidth-16, y+8), img)
-			y += 2 * 8
+		if widget.section != section {
+			section = widget.section
+			if section != nil {
+				y = heading(s, section, y, img)
+			}
+		}
+
+		if widget.fileInfo.IsDir() {
+			name := widget.label() + "/"
+			if button(s, &widget.button1, name, image.Rect(8, y, 8+buttonWidth(face, name), y+buttonHeight), img, keyEvent) {
+				s.navigate(filepath.Join(s.dir, widget.fileInfo.Name()))
+			}
+			y = help(s, widget.settings.Help, y+buttonHeight+4, img) - 4
+		} else if widget.guiSize != image.ZP { // has a remote GUI
+			label(s, widget.label(), image.Rect(8, y, windowWidth-8, y+lineHeight), img)
+			y = help(s, widget.settings.Help, y+lineHeight, img)
 
+			guiRect := image.Rect(8, y, 8+widget.guiSize.X, y+widget.guiSize.Y)
+			focused := s.focusable(widget, guiRect)
 			widget.guiLock.Lock()
-			draw.Draw(img, image.Rect(8, y, 8+widget.guiSize.X, y+widget.guiSize.Y), widget.lastGuiImg, image.ZP, draw.Src)
+			draw.Draw(img, guiRect, widget.lastGuiImg, image.ZP, draw.Src)
+			if pointerIn(guiRect, pointerEvent) && widget.guiCursor != nil {
+				s.cursor = widget.guiCursor
+			}
 			widget.guiLock.Unlock()
-			y += widget.guiSize.Y + 8
-		} else if widget.fileInfo.Mode().Perm()&0111 != 0 { // executable
-			label := widget.fileInfo.Name()
-			if widget.running {
-				label += "..."
+			if focused {
+				focusRing(guiRect, img)
 			}
-			if button(&widget.button1, label, image.Rect(8, y, 30*8, y+3*8), img, pointerEvent) && !widget.running {
-				cmd := &exec.Cmd{Path: widget.fileInfo.Name(), Dir: wdir, Stdout: os.Stdout, Stderr: os.Stderr}
-				widget.running = true
-				go func(widget *Widget, cmd *exec.Cmd) {
-					if err := cmd.Run(); err != nil {
-						log.Printf("exec failed: %v", err)
-					}
-					widget.running = false
-				}(widget, cmd)
+
+			if pointerIn(guiRect, pointerEvent) {
+				guiPointerEvent := rfb.PointerEvent{
+					ButtonMask: pointerEvent.ButtonMask,
+					X:          pointerEvent.X - uint16(guiRect.Min.X),
+					Y:          pointerEvent.Y - uint16(guiRect.Min.Y),
+				}
+				if guiPointerEvent != widget.guiPointer {
+					sendGuiEvent(widget, guiPointerEvent)
+					widget.guiPointer = guiPointerEvent
+				}
 			}
-			y += 3 * 8
-		} else { // not executable
-			label(widget.fileInfo.Name(), image.Rect(8, y, windowWidth-16, y+8), img)
-			y += 2 * 8
+			if focused && keyEvent != nil {
+				sendGuiEvent(widget, *keyEvent)
+			}
+			y += widget.guiSize.Y + 8
+		} else if widget.image != nil {
+			label(s, widget.label(), image.Rect(8, y, windowWidth-8, y+lineHeight), img)
+			y = help(s, widget.settings.Help, y+lineHeight, img)
+
+			imgRect := widget.image.Bounds().Sub(widget.image.Bounds().Min).Add(image.Pt(8, y))
+			draw.Draw(img, imgRect, widget.image, widget.image.Bounds().Min, draw.Over)
+			y += imgRect.Dy() + 8
+		} else if widget.isButton() {
+			y = executable(s, widget, widgets, y, img, keyEvent)
+		} else { // text field
+			label(s, widget.label(), image.Rect(8, y, windowWidth-8, y+lineHeight), img)
+			y = help(s, widget.settings.Help, y+lineHeight, img)
 
 			x := 8
+			loadWidth := buttonWidth(face, "Load...")
+			saveWidth := buttonWidth(face, "Save...")
+
+			if widget.multiline && widget.kind == textKind {
+				editRect := image.Rect(x, y, windowWidth-8, y+textAreaRows*lineHeight+8)
+				textArea(s, &widget.editor, &widget.content, editRect, img, keyEvent)
+				y = editRect.Max.Y + 8
+			} else {
+				editRect := image.Rect(x, y, windowWidth-8-loadWidth-8-saveWidth-8, y+buttonHeight)
+				switch widget.kind {
+				case checkboxKind:
+					checkbox(s, widget, editRect, img, keyEvent)
+				case numberKind:
+					spinBox(s, widget, editRect, img, keyEvent)
+				case sliderKind:
+					slider(s, widget, editRect, img, keyEvent)
+				case dropdownKind:
+					dropdown(s, widget, editRect, img, keyEvent)
+				default:
+					edit(s, &widget.editor, &widget.content, editRect, img, keyEvent)
+				}
+				x = editRect.Max.X + 8
+			}
 
-			edit(&widget.editor, &widget.content, image.Rect(x, y, x+22*8, y+3*8), img, keyEvent, pointerEvent)
-			x += 23 * 8
+			if widget.stale && !widget.loading && !widget.saving && s.focus != &widget.editor && widget.content == widget.diskContent {
+				widget.stale = false
+				widget.load() // changed on disk, and there are no edits to lose
+			}
 
 			label := "Load"
 			if widget.loading {
 				label += "..."
 			}
-			if button(&widget.button1, "Load", image.Rect(x, y, x+7*8, y+3*8), img, pointerEvent) && !widget.loading && !widget.saving {
-				widget.loading = true
-				go func(widget *Widget) {
-					path := filepath.Join(wdir, widget.fileInfo.Name())
-					f, err := os.Open(path)
-					if err != nil {
-						log.Printf("couldn't open %q: %v", path, err)
-						widget.loading = false
-						return
-					}
-					defer f.Close()
-
-					scanner := bufio.NewScanner(f)
-					if scanner.Scan() {
-						widget.content = scanner.Text()
-					}
-
-					if err = scanner.Err(); err != nil {
-						log.Printf("couldn't read %q: %v", path, err)
-					}
-					widget.loading = false
-				}(widget)
+			if button(s, &widget.button1, label, image.Rect(x, y, x+loadWidth, y+buttonHeight), img, keyEvent) && !widget.loading && !widget.saving {
+				widget.stale = false
+				widget.load()
 			}
-			x += 8 * 8
+			x += loadWidth + 8
 
 			label = "Save"
 			if widget.saving {
 				label += "..."
 			}
-			if button(&widget.button2, label, image.Rect(x, y, x+7*8, y+3*8), img, pointerEvent) && !widget.loading && !widget.saving {
-				widget.saving = true
-				go func(widget *Widget, content string) {
-					path := filepath.Join(wdir, widget.fileInfo.Name())
-					if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
-						log.Printf("couldn't write %q: %v", path, err)
-					}
-					widget.saving = false
-				}(widget, widget.content)
+			invalid, pending := widget.invalid()
+			if invalid != "" || pending {
+				disabledButton(s, label, image.Rect(x, y, x+saveWidth, y+buttonHeight), img)
+			} else if button(s, &widget.button2, label, image.Rect(x, y, x+saveWidth, y+buttonHeight), img, keyEvent) && !widget.loading && !widget.saving {
+				widget.startSave(false)
+			}
+			y += buttonHeight
+			y = conflictPrompt(s, widget, y, img, keyEvent)
+			if widget.kind == dropdownKind {
+				y = optionList(s, widget, y, img, keyEvent)
 			}
 
-			y += 3 * 8
+			if invalid != "" {
+				y = paragraph(s, invalid, failureColor, y+4, img)
+			}
 		}
 
 		y += 8
@@ -213,25 +578,170 @@ func updateUI(img draw.Image, keyEvent *rfb.KeyEvent, pointerEvent *rfb.PointerE
 		}
 	}
 
-	return image.Rect(0, 0, windowWidth, y)
+	return viewport(s, y+s.scroll, img, keyEvent)
+}
+
+// sendGuiEvent forwards input to widget's editor, dropping it if the editor is falling behind.
+func sendGuiEvent(widget *Widget, event interface{}) {
+	select {
+	case widget.guiEvents <- event:
+	default:
+		log.Printf("dropped input event for %q", widget.fileInfo.Name())
+	}
+}
+
+func pointerIn(rect image.Rectangle, pointerEvent *rfb.PointerEvent) bool {
+	return image.Pt(int(pointerEvent.X), int(pointerEvent.Y)).In(rect)
+}
+
+// load reads the widget's file into its field in the background.
+func (widget *Widget) load() {
+	widget.loading = true
+	go func(widget *Widget, path string) {
+		content, version, err := readVersion(path)
+		formsLock.Lock()
+		defer formsLock.Unlock()
+		if err != nil {
+			log.Printf("couldn't read %q: %v", path, err)
+			widget.loading = false
+			return
+		}
+
+		widget.setText(string(content))
+		widget.diskContent = widget.content
+		widget.version = &version
+		widget.conflict = nil
+		widget.loaded = true
+		widget.loading = false
+	}(widget, widget.path())
+}
+
+// setText shows text, as it would be stored in the widget's file, in the widget's field.
+func (widget *Widget) setText(text string) {
+	var trailingNewline bool
+	widget.content, widget.multiline, trailingNewline = widget.fieldText(text)
+	if !widget.multiline {
+		widget.trailingNewline = trailingNewline
+	}
+}
+
+// fieldText returns text, as it would be stored in the widget's file, as it's shown in the widget's field, along with whether the field is a text area and whether a newline was left off the end.
+func (widget *Widget) fieldText(text string) (content string, multiline, trailingNewline bool) {
+	multiline = widget.settings.Type == "textarea" || strings.Contains(strings.TrimSuffix(text, "\n"), "\n")
+	if multiline {
+		return text, true, false
+	}
+	return strings.TrimSuffix(text, "\n"), false, strings.HasSuffix(text, "\n")
+}
+
+// fileContent is the text that Save writes to the widget's file.
+func (widget *Widget) fileContent() string {
+	if widget.trailingNewline {
+		return widget.content + "\n"
+	}
+	return widget.content
+}
+
+// isMultiline reports whether the file at path has more than one line, judging by its beginning.
+func isMultiline(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(f, buf)
+	text := string(buf[:n])
+	if n < len(buf) {
+		text = strings.TrimSuffix(text, "\n")
+	}
+	return strings.Contains(text, "\n")
+}
+
+// label draws text in rect, returning its width.
+func label(s *session, text string, rect image.Rectangle, img draw.Image) int {
+	return coloredLabel(s, text, color.Black, rect, img)
 }
 
-func label(text string, rect image.Rectangle, img draw.Image) {
+func coloredLabel(s *session, text string, c color.Color, rect image.Rectangle, img draw.Image) int {
 	fd := &font.Drawer{
-		Dst:  img,
-		Src:  image.NewUniform(color.Black),
-		Face: basicfont.Face7x13,
-		Dot:  fixed.Point26_6{fixed.I(rect.Min.X), fixed.I(rect.Max.Y)},
+		Dst:  clip{img, rect},
+		Src:  image.NewUniform(c),
+		Face: s.face(),
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X), Y: fixed.I(rect.Min.Y + baseline)},
 	}
 	fd.DrawString(text)
+	return (fd.Dot.X - fixed.I(rect.Min.X)).Ceil()
 }
 
-func button(state *ButtonState, text string, rect image.Rectangle, img draw.Image, pointerEvent *rfb.PointerEvent) bool {
-	hovering := image.Pt(int(pointerEvent.X), int(pointerEvent.Y)).In(rect)
-	buttonDown := pointerEvent.ButtonMask&1 != 0
+// paragraph draws text in color c starting at y, wrapping it to the width of the form, and returns the bottom of what it drew.
+func paragraph(s *session, text string, c color.Color, y int, img draw.Image) int {
+	for _, line := range wrap(s.face(), text, windowWidth-16) {
+		coloredLabel(s, line, c, image.Rect(8, y, windowWidth-8, y+lineHeight), img)
+		y += lineHeight
+	}
+	return y
+}
+
+// help draws help text for the widget above it, if there is any, starting at y. It returns the bottom of what it drew.
+func help(s *session, text string, y int, img draw.Image) int {
+	if text == "" {
+		return y
+	}
+	return paragraph(s, text, helpColor, y, img) + 4
+}
+
+// heading draws the title and help for section starting at y, returning where its first widget goes.
+func heading(s *session, section *manifestSection, y int, img draw.Image) int {
+	if section.Title == "" && section.Help == "" {
+		return y
+	}
+	if section.Title != "" {
+		coloredLabel(s, section.Title, primaryColor, image.Rect(8, y, windowWidth-8, y+lineHeight), img)
+		y += lineHeight
+		draw.Draw(img, image.Rect(8, y, windowWidth-8, y+1), image.NewUniform(primaryColor), image.ZP, draw.Src)
+		y += 4
+	}
+	return help(s, section.Help, y, img) + 4
+}
+
+// wrap breaks text into lines that fit in width pixels, breaking at spaces where possible.
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && textWidth(face, candidate) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// buttonWidth returns the width of a button that fits text.
+func buttonWidth(face font.Face, text string) int {
+	return textWidth(face, text) + 16
+}
+
+// click handles input for something clickable in rect, reporting whether it has focus, whether the pointer is over it, and whether it was clicked or activated with Return or Space.
+func click(s *session, state *ButtonState, rect image.Rectangle, keyEvent *rfb.KeyEvent) (focused, hovering, clicked bool) {
+	focused = s.focusable(state, rect)
+	hovering = pointerIn(rect, &s.pointerEvent)
+	buttonDown := s.pointerEvent.ButtonMask&1 != 0
+	if hovering {
+		s.cursor = handCursor
+	}
 
 	// TODO: Require that the click started on the button.
-	var clicked bool
 	if state.clicking {
 		if !buttonDown {
 			clicked = hovering
@@ -242,6 +752,19 @@ func button(state *ButtonState, text string, rect image.Rectangle, img draw.Imag
 			state.clicking = true
 		}
 	}
+	if focused && keyEvent != nil && keyEvent.Pressed {
+		switch keyEvent.KeySym {
+		case 0xff0d, 0xff8d, ' ': // Return, KP_Enter, space
+			clicked = true
+		}
+	}
+	return focused, hovering, clicked
+}
+
+// button draws a button and reports whether it was clicked, or activated with Return or Space while focused.
+func button(s *session, state *ButtonState, text string, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) bool {
+	focused, hovering, clicked := click(s, state, rect, keyEvent)
+	buttonDown := s.pointerEvent.ButtonMask&1 != 0
 
 	c := image.Uniform{primaryColor}
 	if hovering {
@@ -252,63 +775,112 @@ func button(state *ButtonState, text string, rect image.Rectangle, img draw.Imag
 		}
 	}
 	draw.Draw(img, rect, &c, image.ZP, draw.Src)
+	if focused {
+		focusRing(rect, img)
+	}
 
 	fd := &font.Drawer{
 		Dst:  img,
 		Src:  image.NewUniform(color.White),
-		Face: basicfont.Face7x13,
-		Dot:  fixed.Point26_6{fixed.I(rect.Min.X + 8), fixed.I(rect.Max.Y - 8)},
+		Face: s.face(),
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X + 8), Y: fixed.I(rect.Min.Y + (rect.Dy()-lineHeight)/2 + baseline)},
 	}
 	fd.DrawString(text)
 
 	return clicked
 }
 
-func edit(state *EditorState, text *string, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent, pointerEvent *rfb.PointerEvent) {
-	draw.Draw(img, rect, image.NewUniform(color.Black), image.ZP, draw.Src)
-	draw.Draw(img, rect.Inset(1), image.NewUniform(color.White), image.ZP, draw.Src)
-
-	hovering := image.Pt(int(pointerEvent.X), int(pointerEvent.Y)).In(rect)
-	if hovering {
-		if keyEvent.Pressed {
-			if state.lastKeySym != keyEvent.KeySym {
-				if keyEvent.KeySym >= 32 && keyEvent.KeySym <= 126 {
-					*text += string([]uint8{uint8(keyEvent.KeySym)})
-				} else if keyEvent.KeySym == 0xff08 {
-					*text = (*text)[:len(*text)-1]
-				}
-			}
-			state.lastKeySym = keyEvent.KeySym
-		} else {
-			state.lastKeySym = 0
-		}
+// disabledButton draws a button that can't be clicked or focused.
+func disabledButton(s *session, text string, rect image.Rectangle, img draw.Image) {
+	draw.Draw(img, rect, image.NewUniform(disabledColor), image.ZP, draw.Src)
+	fd := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.White),
+		Face: s.face(),
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X + 8), Y: fixed.I(rect.Min.Y + (rect.Dy()-lineHeight)/2 + baseline)},
 	}
+	fd.DrawString(text)
+}
+
+// link draws text that can be clicked like a button, underlined while the pointer is over it.
+func link(s *session, state *ButtonState, text string, rect image.Rectangle, img draw.Image, keyEvent *rfb.KeyEvent) bool {
+	focused, hovering, clicked := click(s, state, rect, keyEvent)
 
 	fd := &font.Drawer{
 		Dst:  img,
-		Src:  image.NewUniform(color.Black),
-		Face: basicfont.Face7x13,
-		Dot:  fixed.Point26_6{fixed.I(rect.Min.X + 8), fixed.I(rect.Max.Y - 8)},
+		Src:  image.NewUniform(primaryColor),
+		Face: s.face(),
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X), Y: fixed.I(rect.Min.Y + (rect.Dy()-lineHeight)/2 + baseline)},
+	}
+	fd.DrawString(text)
+	if hovering {
+		underline := rect.Min.Y + (rect.Dy()-lineHeight)/2 + baseline + 2
+		draw.Draw(img, image.Rect(rect.Min.X, underline, rect.Max.X, underline+1), image.NewUniform(primaryColor), image.ZP, draw.Src)
 	}
-	fd.DrawString(*text)
+	if focused {
+		focusRing(rect, img)
+	}
+
+	return clicked
 }
 
-func nestRfb(cmd *exec.Cmd, imgs chan image.Image) (image.Rectangle, error) {
-	ln, err := net.Listen("tcp", "127.0.0.1:")
-	if err != nil {
-		return image.ZR, fmt.Errorf("couldn't listen: %v", err)
+// breadcrumbs draws a Back button and links to each directory from wdir to s.dir, starting at y. It returns the bottom of what it drew.
+func breadcrumbs(s *session, y int, img draw.Image, keyEvent *rfb.KeyEvent) int {
+	face := s.face()
+	height := lineHeight + 8
+
+	rect := image.Rect(8, y, 8+buttonWidth(face, "Back"), y+height)
+	if button(s, &s.backButton, "Back", rect, img, keyEvent) {
+		s.back()
 	}
 
-	log.Printf("starting subprocess at %s…", ln.Addr().String())
-	cmd.Args = append([]string{cmd.Args[0], "--parent_vnc_addr", ln.Addr().String()}, cmd.Args[1:]...)
-	if err := cmd.Start(); err != nil {
-		return image.ZR, fmt.Errorf("couldn't start subprocess: %v", err)
+	names := []string{filepath.Base(wdir)}
+	if abs, err := filepath.Abs(wdir); err == nil {
+		names[0] = filepath.Base(abs)
+	}
+	names = append(names, strings.Split(s.dir, string(filepath.Separator))...)
+	for len(s.crumbs) < len(names) {
+		s.crumbs = append(s.crumbs, new(ButtonState))
 	}
 
-	log.Print("waiting for subprocess connection…")
-	conn, err := ln.Accept()
+	x := rect.Max.X + 8
+	for i, name := range names {
+		if i > 0 {
+			x += label(s, "/", image.Rect(x, y+(height-lineHeight)/2, windowWidth-8, y+(height+lineHeight)/2), img) + 4
+		}
+		rect := image.Rect(x, y, x+textWidth(face, name), y+height)
+		if i == len(names)-1 {
+			label(s, name, image.Rect(x, y+(height-lineHeight)/2, windowWidth-8, y+(height+lineHeight)/2), img)
+		} else if link(s, s.crumbs[i], name, rect, img, keyEvent) {
+			s.navigate(filepath.Join(names[1 : i+1]...))
+		}
+		x = rect.Max.X + 4
+	}
+
+	return y + height
+}
+
+// focusRing outlines rect to show that it has keyboard focus.
+func focusRing(rect image.Rectangle, img draw.Image) {
+	outer := rect.Inset(-3)
+	c := image.NewUniform(primaryLightColor)
+	draw.Draw(img, image.Rect(outer.Min.X, outer.Min.Y, outer.Max.X, outer.Min.Y+2), c, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(outer.Min.X, outer.Max.Y-2, outer.Max.X, outer.Max.Y), c, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(outer.Min.X, outer.Min.Y, outer.Min.X+2, outer.Max.Y), c, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(outer.Max.X-2, outer.Min.Y, outer.Max.X, outer.Max.Y), c, image.ZP, draw.Src)
+}
+
+// nestRfb runs cmd as an RFB editor, which connects back to dirgui (see startUnixEditor). Input events (rfb.PointerEvent and rfb.KeyEvent values from events) are forwarded to it.
+func nestRfb(cmd *exec.Cmd, imgs chan image.Image, cursors chan *rfb.Cursor, events chan interface{}) (image.Rectangle, error) {
+	var conn net.Conn
+	var err error
+	if *nestTCP {
+		conn, err = startTCPEditor(cmd)
+	} else {
+		conn, err = startUnixEditor(cmd)
+	}
 	if err != nil {
-		log.Fatalf("couldn't accept connection: %v", err)
+		return image.ZR, err
 	}
 
 	bounds := make(chan image.Rectangle, 1)
@@ -318,24 +890,61 @@ func nestRfb(cmd *exec.Cmd, imgs chan image.Image) (image.Rectangle, error) {
 	imageCallback := func(img *rfb.PixelFormatImage) {
 		imgs <- img
 	}
+	cursorCallback := func(cursor *rfb.Cursor) {
+		cursors <- cursor
+	}
+
+	go func() {
+		defer conn.Close() // once events is closed, which ends rfbClient too
+
+		var bo = binary.BigEndian
+		buf := make([]byte, 1+rfb.KeyEventEncodingLength)
+		for event := range events {
+			var msg []byte
+			switch e := event.(type) {
+			case rfb.PointerEvent:
+				buf[0] = 5 // PointerEvent
+				e.Write(buf[1:], bo)
+				msg = buf[:1+rfb.PointerEventEncodingLength]
+			case rfb.KeyEvent:
+				buf[0] = 4 // KeyEvent
+				e.Write(buf[1:], bo)
+				msg = buf[:1+rfb.KeyEventEncodingLength]
+			}
+			if _, err := conn.Write(msg); err != nil {
+				log.Printf("[nestRfb] couldn't forward input event: %v", err)
+				return
+			}
+		}
+	}()
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		defer cmd.Process.Kill()
+		defer close(imgs)
+		defer close(cursors)
 
 		log.Print("starting VNC client for subprocess…")
-		if err := rfbClient(conn, boundsCallback, imageCallback); err != nil {
+		if err := rfbClient(conn, boundsCallback, imageCallback, cursorCallback); err != nil {
 			log.Printf("[rfbClient] client failed: %v", err)
 		}
-		if err := conn.Close(); err != nil {
-			log.Printf("couldn't close connection: %v", err)
-		}
+		conn.Close()
 	}()
 
-	return <-bounds, nil
+	select {
+	case rect := <-bounds:
+		return rect, nil
+	case <-done:
+		return image.ZR, fmt.Errorf("editor disconnected before sending its size")
+	case <-time.After(editorTimeout):
+		conn.Close()
+		return image.ZR, fmt.Errorf("editor didn't send its size within %v", editorTimeout)
+	}
 }
 
-// rfbClient communicates over conn as an RFB 3.3 client and calls callback with the composite framebuffer after each update, then requests another update. callback must not retain the image after it returns.
-func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callback func(*rfb.PixelFormatImage)) error {
+// rfbClient communicates over conn as an RFB 3.3 client and calls callback with the composite framebuffer after each update, then requests another update. callback must not retain the image after it returns. cursorCallback is called whenever the server changes the cursor.
+func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callback func(*rfb.PixelFormatImage), cursorCallback func(*rfb.Cursor)) error {
 	buf := make([]byte, 256)
 
 	var bo = binary.BigEndian
@@ -381,6 +990,15 @@ func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callbac
 		return fmt.Errorf("couldn't read server name: %v", err)
 	}
 
+	buf[0] = 2 // SetEncodings
+	buf[1] = 0 // padding
+	bo.PutUint16(buf[2:], 2)
+	bo.PutUint32(buf[4:], rfb.RawEncoding)
+	bo.PutUint32(buf[8:], rfb.CursorPseudoEncoding)
+	if _, err := conn.Write(buf[:12]); err != nil {
+		return fmt.Errorf("couldn't write SetEncodings: %v", err)
+	}
+
 	boundsCallback(image.Rect(0, 0, int(width), int(height)))
 	framebuffer = rfb.NewPixelFormatImage(pixelFormat, image.Rect(0, 0, int(width), int(height)))
 	updateRequest = rfb.FramebufferUpdateRequest{
@@ -410,6 +1028,13 @@ func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callbac
 				if err := rect.Read(conn, bo, pixelFormat); err != nil {
 					return fmt.Errorf("couldn't read rectangle %d: %v", i, err)
 				}
+				switch rect.EncodingType {
+				case rfb.CursorPseudoEncoding:
+					cursorCallback(rfb.DecodeCursor(&rect, pixelFormat))
+					continue
+				case rfb.DesktopSizePseudoEncoding:
+					continue // not requested, and it has no pixels to draw
+				}
 				img := &rfb.PixelFormatImage{
 					Pix:         rect.PixelData,
 					Rect:        image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height)),
@@ -417,12 +1042,12 @@ func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callbac
 				}
 				draw.Draw(framebuffer, framebuffer.Bounds(), img, image.ZP, draw.Src)
 				callback(framebuffer)
+			}
 
-				buf[0] = 3 // FramebufferUpdateRequest
-				updateRequest.Write(buf[1:], bo)
-				if _, err := conn.Write(buf[:1+rfb.FramebufferUpdateRequestEncodingLength]); err != nil {
-					return fmt.Errorf("couldn't write FramebufferUpdateRequest: %v", err)
-				}
+			buf[0] = 3 // FramebufferUpdateRequest
+			updateRequest.Write(buf[1:], bo)
+			if _, err := conn.Write(buf[:1+rfb.FramebufferUpdateRequestEncodingLength]); err != nil {
+				return fmt.Errorf("couldn't write FramebufferUpdateRequest: %v", err)
 			}
 
 		case 1: // SetColourMapEntries
@@ -444,6 +1069,4 @@ func rfbClient(conn io.ReadWriter, boundsCallback func(image.Rectangle), callbac
 		}
 
 	}
-
-	return nil
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validatorSuffix is appended to a file's name to name an executable that validates its field. A validator gets the candidate value on stdin and the file's name as its argument, and exits with a failure status, explaining why on stdout or stderr, if the value can't be saved.
+const validatorSuffix = ".validate"
+
+// validatorTimeout is how long a validator may run before the value it's checking is rejected.
+const validatorTimeout = 5 * time.Second
+
+// findValidator returns the validator for the field for the file at path, relative to its directory, or "" if it doesn't have one.
+func findValidator(path string, settings fileSettings) string {
+	if settings.Validator != "" {
+		return settings.Validator
+	}
+	if info, err := os.Stat(path + validatorSuffix); err == nil && !info.IsDir() && isExecutable(info) {
+		return filepath.Base(path) + validatorSuffix
+	}
+	return ""
+}
+
+// invalid explains why the field's value can't be saved, or returns "" if it can be. Validators run in the background, so pending is true until the field's validator has checked the current value, and until then problem is about the last value it checked.
+func (widget *Widget) invalid() (problem string, pending bool) {
+	if problem := widget.checkRules(); problem != "" {
+		return problem, false
+	}
+	if widget.validator == "" {
+		return "", false
+	}
+
+	widget.validationLock.Lock()
+	defer widget.validationLock.Unlock()
+	value := widget.fileContent()
+	if widget.validating {
+		return widget.verdict, true
+	}
+	if !widget.validated || widget.checkedValue != value {
+		widget.validating = true
+		go func(widget *Widget, validator, name, value string) {
+			verdict := widget.runValidator(validator, name, value)
+			widget.validationLock.Lock()
+			widget.validating = false
+			widget.validated = true
+			widget.checkedValue = value
+			widget.verdict = verdict
+			widget.validationLock.Unlock()
+		}(widget, widget.validator, widget.fileInfo.Name(), value)
+		return widget.verdict, true
+	}
+	return widget.verdict, false
+}
+
+// checkRules checks the field's value against its pattern, its range, its options, and whether it has to be JSON.
+func (widget *Widget) checkRules() string {
+	value := widget.content
+	if p := widget.settings.pattern; p != nil && !p.MatchString(value) {
+		return "Must match " + p.String()
+	}
+	if widget.settings.JSON {
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Sprintf("Must be valid JSON: %v", err)
+		}
+	}
+	if widget.kind == numberKind || widget.kind == sliderKind || widget.hasRange {
+		trimmed := strings.TrimSpace(value)
+		if !numberPattern.MatchString(trimmed) {
+			return "Must be a number"
+		}
+		if v, _ := strconv.ParseFloat(trimmed, 64); widget.hasRange && (v < widget.min || v > widget.max) {
+			return fmt.Sprintf("Must be from %v to %v", widget.min, widget.max)
+		}
+	}
+	if widget.kind == dropdownKind {
+		for _, option := range widget.options {
+			if value == option {
+				return ""
+			}
+		}
+		return "Must be one of the options"
+	}
+	return ""
+}
+
+// runValidator runs validator on value for the field's file, named name, returning why it was rejected, or "" if it wasn't.
+func (widget *Widget) runValidator(validator, name, value string) string {
+	var out bytes.Buffer
+	cmd := &exec.Cmd{
+		Path:   validator,
+		Args:   []string{validator, name},
+		Dir:    widget.dir,
+		Stdin:  strings.NewReader(value),
+		Stdout: &out,
+		Stderr: &out,
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Sprintf("Couldn't run %s: %v", validator, err)
+	}
+	timer := time.AfterFunc(validatorTimeout, func() {
+		cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if err == nil {
+		return ""
+	}
+	if problem := strings.TrimSpace(out.String()); problem != "" {
+		return problem
+	}
+	return fmt.Sprintf("Rejected by %s (%v)", validator, err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/draw"
+)
+
+var maxHeight = flag.Int("max_height", 768, "Maximum height of the desktop in pixels; taller forms scroll")
+
+// scrollStep is how far one click of the mouse wheel scrolls, in pixels.
+const scrollStep = 48
+
+// viewport scrolls s's view of a form that's height pixels tall, with the mouse wheel, PageUp and PageDown, and a scroll bar that it draws on img. It returns the bounds of the view.
+func viewport(s *session, height int, img draw.Image, keyEvent *rfb.KeyEvent) image.Rectangle {
+	viewHeight := height
+	if viewHeight > *maxHeight {
+		viewHeight = *maxHeight
+	}
+	maxScroll := height - viewHeight
+	page := viewHeight - 2*lineHeight
+
+	if keyEvent != nil && keyEvent.Pressed {
+		switch keyEvent.KeySym {
+		case 0xff55, 0xff9a: // Prior (PageUp), KP_Prior
+			s.scroll -= page
+		case 0xff56, 0xff9b: // Next (PageDown), KP_Next
+			s.scroll += page
+		}
+	}
+	if s.pressed&(1<<3) != 0 { // wheel up
+		s.scroll -= scrollStep
+	}
+	if s.pressed&(1<<4) != 0 { // wheel down
+		s.scroll += scrollStep
+	}
+	if s.revealFocus {
+		// focusRect was drawn at the old scroll position.
+		if s.focusRect.Min.Y < 8 {
+			s.scroll += s.focusRect.Min.Y - 8
+		} else if s.focusRect.Max.Y > viewHeight-8 {
+			s.scroll += s.focusRect.Max.Y - (viewHeight - 8)
+		}
+		s.revealFocus = false
+	}
+
+	if maxScroll > 0 { // scroll bar
+		track := image.Rect(windowWidth-6, 2, windowWidth-2, viewHeight-2)
+		thumbHeight := track.Dy() * viewHeight / height
+		thumbTop := func() int {
+			return track.Min.Y + (track.Dy()-thumbHeight)*clampScroll(s.scroll, maxScroll)/maxScroll
+		}
+
+		pt := image.Pt(int(s.pointerEvent.X), int(s.pointerEvent.Y))
+		if s.pressed&1 != 0 && pt.In(image.Rect(windowWidth-8, 0, windowWidth, viewHeight)) {
+			if top := thumbTop(); pt.Y < top {
+				s.scroll -= page
+			} else if pt.Y >= top+thumbHeight {
+				s.scroll += page
+			} else {
+				s.scrollGrab = pt.Y - top
+				s.scrollDragging = true
+			}
+		}
+		if s.scrollDragging {
+			if s.pointerEvent.ButtonMask&1 == 0 {
+				s.scrollDragging = false
+			} else if track.Dy() > thumbHeight {
+				s.scroll = (pt.Y - s.scrollGrab - track.Min.Y) * maxScroll / (track.Dy() - thumbHeight)
+			}
+		}
+
+		top := thumbTop()
+		draw.Draw(img, image.Rect(track.Min.X, top, track.Max.X, top+thumbHeight), image.NewUniform(primaryLightColor), image.ZP, draw.Src)
+	}
+	s.scroll = clampScroll(s.scroll, maxScroll)
+
+	return image.Rect(0, 0, windowWidth, viewHeight)
+}
+
+func clampScroll(scroll, maxScroll int) int {
+	if scroll > maxScroll {
+		scroll = maxScroll
+	}
+	if scroll < 0 {
+		scroll = 0
+	}
+	return scroll
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often directories are checked for changes when they can't be watched.
+const pollInterval = time.Second
+
+// watchForm keeps the form for dir (relative to wdir) up to date with the files in it until the directory goes away.
+func watchForm(dir string) {
+	path := filepath.Join(wdir, dir)
+	changed := func() bool {
+		if err := refreshForm(dir); err != nil {
+			log.Printf("couldn't refresh form: %v", err)
+			return false
+		}
+		return true
+	}
+
+	err := watchDir(path, changed)
+	if err == nil {
+		return
+	}
+	log.Printf("couldn't watch %q, so polling it instead: %v", path, err)
+	pollDir(path, changed)
+}
+
+// pollDir calls changed whenever an entry in dir looks different, until it returns false or dir can't be read.
+func pollDir(dir string, changed func() bool) {
+	last, err := dirSignature(dir)
+	for err == nil {
+		time.Sleep(pollInterval)
+
+		var sig string
+		if sig, err = dirSignature(dir); err != nil || sig != last {
+			if !changed() {
+				return
+			}
+		}
+		last = sig
+	}
+}
+
+// dirSignature summarizes the names, modes, sizes, and modification times of the entries in dir.
+func dirSignature(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, info := range infos {
+		fmt.Fprintf(&b, "%q %v %d %d\n", info.Name(), info.Mode(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
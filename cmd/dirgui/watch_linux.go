@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchDelay is how long to wait after a change for related changes, such as a file being replaced, to arrive before refreshing.
+const watchDelay = 50 * time.Millisecond
+
+// watchEvents are the inotify events that can change a form.
+const watchEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watchDir calls changed whenever an entry in dir is created, deleted, renamed, modified, or chmod'ed, until it returns false or dir goes away. It returns an error if dir can't be watched.
+func watchDir(dir string, changed func() bool) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("couldn't initialize inotify: %v", err)
+	}
+	defer syscall.Close(fd)
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchEvents); err != nil {
+		return fmt.Errorf("couldn't add inotify watch: %v", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("couldn't read inotify events: %v", err)
+		}
+
+		gone := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+				gone = true
+			}
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+		}
+
+		time.Sleep(watchDelay)
+		if !changed() || gone {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchDir is only implemented on Linux, so other systems poll.
+func watchDir(dir string, changed func() bool) error {
+	return errors.New("watching is only supported on Linux")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var backupMode = flag.String("backup", "", `Whether saving a field first backs up its file: "" for no, "bak" to copy it to <name>.bak, or "numbered" to copy it to <name>.~1~, <name>.~2~, and so on`)
+
+// maxSymlinks is how many symlinks writeFile follows to find the file to write before giving up.
+const maxSymlinks = 40
+
+// backupPattern matches the names of backups made by -backup, capturing the name of the file that was backed up and the number of a numbered backup.
+var backupPattern = regexp.MustCompile(`^(.*)\.(bak|~([0-9]+)~)$`)
+
+// writeFile replaces the contents of the file at path (or the file it's a symlink to) with data, so that after a crash, it has either its old contents or the new ones, unless its directory isn't writable (see replaceFile). The file keeps its mode, and its owner if dirgui is allowed to set it. With backup, the old contents are first backed up as -backup says, and the path of the backup is returned if it's a new file, even if writing data fails.
+func writeFile(path string, data []byte, backup bool) (backupPath string, err error) {
+	target, err := resolveSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return "", replaceFile(target, data, nil)
+	} else if err != nil {
+		return "", err
+	}
+
+	if backup && *backupMode != "" {
+		if backupPath, err = backupFile(target, info); err != nil {
+			return "", fmt.Errorf("couldn't back up %q: %v", target, err)
+		}
+	}
+	return backupPath, replaceFile(target, data, info)
+}
+
+// replaceFile writes data to a temporary file next to path, then renames it to path. If info describes the file being replaced, the new file gets its mode and owner. If the temporary file can't be created, as when the directory isn't writable but the file is, the file is overwritten in place instead, which isn't atomic.
+func replaceFile(path string, data []byte, info os.FileInfo) error {
+	dir, name := filepath.Split(path)
+	var tmp *os.File
+	var err error
+	for {
+		// Hidden, so it isn't shown in the form while it's being written.
+		tmp, err = os.OpenFile(filepath.Join(dir, "."+name+".tmp"+strconv.Itoa(rand.Int())), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		if info == nil {
+			return err // a new file can't be created in place either
+		}
+		log.Printf("couldn't create a temporary file for %q, so overwriting it in place: %v", path, err)
+		return overwriteFile(path, data)
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if info != nil {
+		// Chown before chmod, since changing the owner clears the setuid and setgid bits.
+		if err := preserveOwner(tmp.Name(), info); err != nil {
+			log.Printf("couldn't preserve the owner of %q: %v", path, err)
+		}
+		if err := tmp.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		log.Printf("couldn't sync %q: %v", filepath.Dir(path), err)
+	}
+	return nil
+}
+
+// overwriteFile truncates the existing file at path and writes data to it. After a crash, the file may hold only part of data.
+func overwriteFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// resolveSymlinks returns the path of the file that path refers to, following symlinks even if the file they point to doesn't exist yet.
+func resolveSymlinks(path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) || (err == nil && info.Mode()&os.ModeSymlink == 0) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", fmt.Errorf("couldn't resolve %q: too many symlinks", path)
+}
+
+// backupFile copies the file at path, described by info, to its backup, returning the backup's path if it didn't exist before.
+func backupFile(path string, info os.FileInfo) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var backupPath string
+	switch *backupMode {
+	case "bak":
+		backupPath = path + ".bak"
+	case "numbered":
+		infos, err := ioutil.ReadDir(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+		latest := 0
+		for _, sibling := range infos {
+			if m := backupPattern.FindStringSubmatch(sibling.Name()); m != nil && m[1] == filepath.Base(path) && m[3] != "" {
+				if n, err := strconv.Atoi(m[3]); err == nil && n > latest {
+					latest = n
+				}
+			}
+		}
+		backupPath = fmt.Sprintf("%s.~%d~", path, latest+1)
+	default:
+		return "", fmt.Errorf("unknown backup mode %q", *backupMode)
+	}
+	_, err = os.Lstat(backupPath)
+	created := os.IsNotExist(err)
+	if err := replaceFile(backupPath, data, info); err != nil {
+		return "", err
+	}
+	if !created {
+		return "", nil
+	}
+	return backupPath, nil
+}
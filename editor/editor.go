@@ -0,0 +1,285 @@
+// Package editor implements the RFB side of a dirgui custom editor, so that an editor only has to draw itself and react to input.
+//
+// A typical editor looks like
+//
+//	func main() {
+//		flag.Parse()
+//		// load flag.Arg(0)…
+//		if err := editor.Run(bounds, paint, onPointer, onKey); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+package editor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/alltom/dirgui/rfb"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var parentAddr = flag.String("parent_vnc_addr", "", "If present, instead of starting a VNC server, will connect to the given addr as a VNC server (used by dirgui -nest_tcp)")
+
+// FrameInterval is the minimum time between frames sent to a client.
+var FrameInterval = time.Second / 30
+
+// mu serializes calls to the editor's callbacks.
+var mu sync.Mutex
+
+// Run serves the editor's GUI until the parent dirgui disconnects. When the program wasn't started by dirgui, it serves as a standalone VNC server on port 5900 instead, which is handy for debugging.
+//
+// paint is called whenever a client needs a new frame and must draw the entire bounds. onPointer and onKey are called with each input event, and may be nil. Callbacks are never called concurrently.
+func Run(bounds image.Rectangle, paint func(draw.Image), onPointer func(rfb.PointerEvent), onKey func(rfb.KeyEvent)) error {
+	if bounds.Min != image.ZP {
+		return fmt.Errorf("bounds must start at (0, 0), but they start at %v", bounds.Min)
+	}
+	if onPointer == nil {
+		onPointer = func(rfb.PointerEvent) {}
+	}
+	if onKey == nil {
+		onKey = func(rfb.KeyEvent) {}
+	}
+	s := &server{bounds: bounds, paint: paint, onPointer: onPointer, onKey: onKey}
+
+	if conn, err := dialParent(); err != nil {
+		return err
+	} else if conn != nil {
+		defer conn.Close()
+
+		if err := s.serve(conn); err != nil {
+			return fmt.Errorf("serve failed: %v", err)
+		}
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:5900")
+	if err != nil {
+		return fmt.Errorf("couldn't listen: %v", err)
+	}
+	log.Print("listening…")
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("couldn't accept connection: %v", err)
+		}
+		log.Print("accepted connection")
+		go func(conn net.Conn) {
+			if err := s.serve(conn); err != nil {
+				log.Printf("serve failed: %v", err)
+			}
+			if err := conn.Close(); err != nil {
+				log.Printf("couldn't close connection: %v", err)
+			}
+		}(conn)
+	}
+}
+
+// dialParent connects to the dirgui that started this program, or returns nil if there isn't one.
+func dialParent() (net.Conn, error) {
+	if path := os.Getenv("DIRGUI_SOCKET"); path != "" {
+		token := os.Getenv("DIRGUI_TOKEN")
+		// Don't leak the token to our own subprocesses.
+		os.Unsetenv("DIRGUI_SOCKET")
+		os.Unsetenv("DIRGUI_TOKEN")
+
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to %q: %v", path, err)
+		}
+		if _, err := io.WriteString(conn, token+"\n"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("couldn't write token: %v", err)
+		}
+		return conn, nil
+	}
+
+	if *parentAddr != "" {
+		conn, err := net.Dial("tcp", *parentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to %q: %v", *parentAddr, err)
+		}
+		return conn, nil
+	}
+
+	return nil, nil
+}
+
+type server struct {
+	bounds    image.Rectangle
+	paint     func(draw.Image)
+	onPointer func(rfb.PointerEvent)
+	onKey     func(rfb.KeyEvent)
+}
+
+func (s *server) serve(conn io.ReadWriter) error {
+	buf := make([]byte, 256)
+	w := bufio.NewWriter(conn)
+
+	var bo = binary.BigEndian
+	var pixelFormat = rfb.PixelFormat{
+		BitsPerPixel: 32,
+		BitDepth:     24,
+		BigEndian:    true,
+		TrueColor:    true,
+
+		RedMax:     255,
+		GreenMax:   255,
+		BlueMax:    255,
+		RedShift:   24,
+		GreenShift: 16,
+		BlueShift:  8,
+	}
+	var updateRequest rfb.FramebufferUpdateRequest
+	var update rfb.FramebufferUpdate
+	var keyEvent rfb.KeyEvent
+	var pointerEvent rfb.PointerEvent
+	var lastFrame time.Time
+	frame := image.NewNRGBA(s.bounds)
+
+	if _, err := io.WriteString(conn, "RFB 003.008\n"); err != nil {
+		return fmt.Errorf("couldn't write ProtocolVersion: %v", err)
+	}
+
+	var major, minor int
+	if _, err := io.ReadFull(conn, buf[:12]); err != nil {
+		return fmt.Errorf("couldn't read ProtocolVersion: %v", err)
+	}
+	if _, err := fmt.Sscanf(string(buf[:12]), "RFB %03d.%03d\n", &major, &minor); err != nil {
+		return fmt.Errorf("couldn't parse ProtocolVersion %q: %v", buf[:12], err)
+	}
+
+	if major == 3 && minor == 3 {
+		// RFB 3.3
+		bo.PutUint32(buf, 1)
+		if _, err := conn.Write(buf[:4]); err != nil {
+			return fmt.Errorf("couldn't write authentication scheme: %v", err)
+		}
+	} else if major == 3 && minor == 8 {
+		// RFB 3.8
+		if _, err := conn.Write([]byte{1, 1}); err != nil {
+			return fmt.Errorf("couldn't write security types: %v", err)
+		}
+
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return fmt.Errorf("couldn't read security type: %v", err)
+		}
+		if buf[0] != 1 {
+			return fmt.Errorf("client must use security type 1, got %q", buf[0])
+		}
+
+		if _, err := conn.Write([]byte{0}); err != nil {
+			return fmt.Errorf("couldn't confirm security type: %v", err)
+		}
+	} else {
+		return fmt.Errorf("server only supports RFB 3.3 and 3.8, but client requested %d.%d", major, minor)
+	}
+
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return fmt.Errorf("couldn't read ClientInit: %v", err)
+	}
+
+	bo.PutUint16(buf[0:], uint16(s.bounds.Max.X))
+	bo.PutUint16(buf[2:], uint16(s.bounds.Max.Y))
+	pixelFormat.Write(buf[4:], bo)
+	bo.PutUint32(buf[20:], 6) // length of name
+	copy(buf[24:], "dirgui")
+	if _, err := conn.Write(buf[:30]); err != nil {
+		return fmt.Errorf("couldn't write ServerInit: %v", err)
+	}
+
+	for {
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return fmt.Errorf("couldn't read message type: %v", err)
+		}
+		switch buf[0] {
+		case 0: // SetPixelFormat
+			if _, err := io.ReadFull(conn, buf[:3+rfb.PixelFormatEncodingLength]); err != nil {
+				return fmt.Errorf("couldn't read pixel format in SetPixelFormat: %v", err)
+			}
+			pixelFormat.Read(buf[3:], bo)
+
+		case 2: // SetEncodings
+			if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+				return fmt.Errorf("couldn't read number of encodings in SetEncodings: %v", err)
+			}
+			encodingCount := bo.Uint16(buf[1:])
+			if _, err := io.Copy(ioutil.Discard, &io.LimitedReader{R: conn, N: 4 * int64(encodingCount)}); err != nil {
+				return fmt.Errorf("couldn't read SetEncodings encoding list: %v", err)
+			}
+
+		case 3: // FramebufferUpdateRequest
+			if _, err := io.ReadFull(conn, buf[:rfb.FramebufferUpdateRequestEncodingLength]); err != nil {
+				return fmt.Errorf("couldn't read FramebufferUpdateRequest: %v", err)
+			}
+			updateRequest.Read(buf, bo)
+
+			if updateRequest.Incremental {
+				time.Sleep(time.Until(lastFrame.Add(FrameInterval)))
+			}
+			lastFrame = time.Now()
+
+			mu.Lock()
+			s.paint(frame)
+			mu.Unlock()
+
+			img := rfb.NewPixelFormatImage(pixelFormat, image.Rect(int(updateRequest.X), int(updateRequest.Y), int(updateRequest.X)+int(updateRequest.Width), int(updateRequest.Y)+int(updateRequest.Height)))
+			draw.Draw(img, img.Bounds(), frame, img.Bounds().Min, draw.Src)
+			update.Rectangles = []*rfb.FramebufferUpdateRect{
+				&rfb.FramebufferUpdateRect{
+					X: updateRequest.X, Y: updateRequest.Y, Width: updateRequest.Width, Height: updateRequest.Height,
+					EncodingType: rfb.RawEncoding, PixelData: img.Pix,
+				},
+			}
+
+			if _, err := w.Write([]byte{0, 0}); err != nil { // message type and padding
+				return fmt.Errorf("couldn't write FramebufferUpdate header: %v", err)
+			}
+			if err := update.Write(w, bo); err != nil {
+				return fmt.Errorf("couldn't write FramebufferUpdate: %v", err)
+			}
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("couldn't write FramebufferUpdate: %v", err)
+			}
+
+		case 4: // KeyEvent
+			if _, err := io.ReadFull(conn, buf[:rfb.KeyEventEncodingLength]); err != nil {
+				return fmt.Errorf("couldn't read KeyEvent: %v", err)
+			}
+			keyEvent.Read(buf, bo)
+			mu.Lock()
+			s.onKey(keyEvent)
+			mu.Unlock()
+
+		case 5: // PointerEvent
+			if _, err := io.ReadFull(conn, buf[:rfb.PointerEventEncodingLength]); err != nil {
+				return fmt.Errorf("couldn't read PointerEvent: %v", err)
+			}
+			pointerEvent.Read(buf, bo)
+			mu.Lock()
+			s.onPointer(pointerEvent)
+			mu.Unlock()
+
+		case 6: // ClientCutText
+			if _, err := io.ReadFull(conn, buf[:7]); err != nil {
+				return fmt.Errorf("couldn't read text length in ClientCutText: %v", err)
+			}
+			length := bo.Uint32(buf[3:])
+			if _, err := io.Copy(ioutil.Discard, &io.LimitedReader{R: conn, N: int64(length)}); err != nil {
+				return fmt.Errorf("couldn't read ClientCutText text: %v", err)
+			}
+
+		default:
+			return fmt.Errorf("received unrecognized message %d", buf[0])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rfb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Cursor is a pointer shape that can be sent with the Cursor or X Cursor pseudo-encodings. Pixels that are less than half opaque are transparent.
+type Cursor struct {
+	Image   image.Image
+	Hotspot image.Point // relative to Image.Bounds().Min
+}
+
+// Rect encodes the cursor as a Cursor pseudo-encoding rectangle, with colors in the client's pixel format.
+func (c *Cursor) Rect(pixelFormat PixelFormat) *FramebufferUpdateRect {
+	bounds := c.Image.Bounds()
+	img := NewPixelFormatImage(pixelFormat, image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), c.Image, bounds.Min, draw.Src)
+
+	return &FramebufferUpdateRect{
+		X: uint16(c.Hotspot.X), Y: uint16(c.Hotspot.Y), Width: uint16(bounds.Dx()), Height: uint16(bounds.Dy()),
+		EncodingType: CursorPseudoEncoding,
+		PixelData:    append(img.Pix, c.bitmap(opaque)...),
+	}
+}
+
+// XRect encodes the cursor as an X Cursor pseudo-encoding rectangle, which only has two colors: black for dark pixels and white for light pixels.
+func (c *Cursor) XRect() *FramebufferUpdateRect {
+	bounds := c.Image.Bounds()
+	data := []byte{0, 0, 0, 0xff, 0xff, 0xff} // primary (black) and secondary (white) colors
+	data = append(data, c.bitmap(dark)...)
+	data = append(data, c.bitmap(opaque)...)
+
+	return &FramebufferUpdateRect{
+		X: uint16(c.Hotspot.X), Y: uint16(c.Hotspot.Y), Width: uint16(bounds.Dx()), Height: uint16(bounds.Dy()),
+		EncodingType: XCursorPseudoEncoding,
+		PixelData:    data,
+	}
+}
+
+// DecodeCursor reads a rectangle that was sent with the Cursor pseudo-encoding.
+func DecodeCursor(rect *FramebufferUpdateRect, pixelFormat PixelFormat) *Cursor {
+	bounds := image.Rect(0, 0, int(rect.Width), int(rect.Height))
+	pixelLength := cursorPixelLength(rect, pixelFormat)
+	src := &PixelFormatImage{Pix: rect.PixelData[:pixelLength], Rect: bounds, PixelFormat: pixelFormat}
+	mask := rect.PixelData[pixelLength:]
+	stride := (bounds.Dx() + 7) / 8
+
+	img := image.NewNRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if mask[y*stride+x/8]&(0x80>>uint(x%8)) != 0 {
+				img.Set(x, y, src.At(x, y))
+			}
+		}
+	}
+	return &Cursor{Image: img, Hotspot: image.Pt(int(rect.X), int(rect.Y))}
+}
+
+// bitmap packs one bit per pixel, most significant bit first, with rows padded to whole bytes.
+func (c *Cursor) bitmap(set func(color.Color) bool) []byte {
+	bounds := c.Image.Bounds()
+	stride := (bounds.Dx() + 7) / 8
+	bits := make([]byte, stride*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if set(c.Image.At(bounds.Min.X+x, bounds.Min.Y+y)) {
+				bits[y*stride+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return bits
+}
+
+func opaque(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a >= 0x8000
+}
+
+func dark(c color.Color) bool {
+	gray := color.Gray16Model.Convert(c).(color.Gray16)
+	return gray.Y < 0x8000
+}
+
+func cursorPixelLength(rect *FramebufferUpdateRect, pixelFormat PixelFormat) int {
+	return int(pixelFormat.BitsPerPixel/8) * int(rect.Width) * int(rect.Height)
+}
+
+func cursorMaskLength(rect *FramebufferUpdateRect) int {
+	return (int(rect.Width) + 7) / 8 * int(rect.Height)
+}
@@ -0,0 +1,83 @@
+//go:build ignore
+// +build ignore
+
+// gen_keysyms generates keysyms_table.go from X11's keysymdef.h:
+//
+//	go run gen_keysyms.go /usr/include/X11/keysymdef.h
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var define = regexp.MustCompile(`^#define XK_(\w+)\s+0x([0-9a-fA-F]+)\s*/\* U\+([0-9A-F]{4,6}) `)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("usage: go run gen_keysyms.go path/to/keysymdef.h")
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatalf("couldn't open keysymdef.h: %v", err)
+	}
+	defer f.Close()
+
+	runes := make(map[uint32]rune)
+	names := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := define.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		keysym, _ := strconv.ParseUint(m[2], 16, 32)
+		r, _ := strconv.ParseUint(m[3], 16, 32)
+		if keysym < 0x100 || keysym >= 0x01000000 {
+			continue // Latin-1 and Unicode keysyms are converted without the table
+		}
+		if r < 0x20 || (r >= 0x7f && r < 0xa0) {
+			continue // control characters
+		}
+		if _, ok := runes[uint32(keysym)]; ok {
+			continue // the first name for a keysym is the preferred one
+		}
+		runes[uint32(keysym)] = rune(r)
+		names[uint32(keysym)] = m[1]
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("couldn't read keysymdef.h: %v", err)
+	}
+
+	var keysyms []uint32
+	for keysym := range runes {
+		keysyms = append(keysyms, keysym)
+	}
+	sort.Slice(keysyms, func(i, j int) bool { return keysyms[i] < keysyms[j] })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_keysyms.go from keysymdef.h; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package rfb\n\n")
+	fmt.Fprintf(&buf, "// keySymRunes maps legacy (pre-Unicode) keysyms to the characters they type.\n")
+	fmt.Fprintf(&buf, "var keySymRunes = map[uint32]rune{\n")
+	for _, keysym := range keysyms {
+		fmt.Fprintf(&buf, "\t0x%04x: 0x%04x, // %s\n", keysym, runes[keysym], names[keysym])
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("couldn't format table: %v", err)
+	}
+	if err := ioutil.WriteFile("keysyms_table.go", src, 0666); err != nil {
+		log.Fatalf("couldn't write table: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package rfb
+
+//go:generate go run gen_keysyms.go /usr/include/X11/keysymdef.h
+
+// KeySymRune returns the character typed by keysym, or false if it doesn't type one, as for function and modifier keys.
+func KeySymRune(keysym uint32) (rune, bool) {
+	switch {
+	case keysym >= 0x20 && keysym <= 0x7e, keysym >= 0xa0 && keysym <= 0xff: // Latin-1
+		return rune(keysym), true
+	case keysym >= 0x01000100 && keysym <= 0x0110ffff: // Unicode
+		return rune(keysym - 0x01000000), true
+	case keysym == 0xff80: // KP_Space
+		return ' ', true
+	case keysym >= 0xffb0 && keysym <= 0xffb9: // KP_0 to KP_9
+		return rune('0' + keysym - 0xffb0), true
+	case keysym >= 0xffaa && keysym <= 0xffaf: // KP_Multiply, KP_Add, KP_Separator, KP_Subtract, KP_Decimal, KP_Divide
+		return rune("*+,-./"[keysym-0xffaa]), true
+	case keysym == 0xffbd: // KP_Equal
+		return '=', true
+	}
+	r, ok := keySymRunes[keysym]
+	return r, ok
+}
+
+// Modifiers is the set of modifier keys being held down.
+type Modifiers uint16
+
+const (
+	ShiftLeft Modifiers = 1 << iota
+	ShiftRight
+	ControlLeft
+	ControlRight
+	AltLeft
+	AltRight
+	MetaLeft
+	MetaRight
+	SuperLeft
+	SuperRight
+	AltGr
+
+	Shift   = ShiftLeft | ShiftRight
+	Control = ControlLeft | ControlRight
+	Alt     = AltLeft | AltRight
+	Meta    = MetaLeft | MetaRight
+	Super   = SuperLeft | SuperRight
+)
+
+var modifierKeySyms = map[uint32]Modifiers{
+	0xffe1: ShiftLeft,
+	0xffe2: ShiftRight,
+	0xffe3: ControlLeft,
+	0xffe4: ControlRight,
+	0xffe7: MetaLeft,
+	0xffe8: MetaRight,
+	0xffe9: AltLeft,
+	0xffea: AltRight,
+	0xffeb: SuperLeft,
+	0xffec: SuperRight,
+	0xfe03: AltGr, // ISO_Level3_Shift
+}
+
+// IsModifier reports whether keysym is for one of the modifier keys that Modifiers tracks.
+func IsModifier(keysym uint32) bool {
+	_, ok := modifierKeySyms[keysym]
+	return ok
+}
+
+// Update records the press or release of a modifier key, reporting whether e was for one.
+func (m *Modifiers) Update(e KeyEvent) bool {
+	modifier, ok := modifierKeySyms[e.KeySym]
+	if !ok {
+		return false
+	}
+	if e.Pressed {
+		*m |= modifier
+	} else {
+		*m &^= modifier
+	}
+	return true
+}
@@ -59,6 +59,15 @@ const (
 	PointerEventEncodingLength             = 5
 )
 
+// Encoding types, as stored in FramebufferUpdateRect.EncodingType. Pseudo-encodings are negative on the wire.
+const (
+	RawEncoding           uint32 = 0
+	CursorPseudoEncoding  uint32 = 0xffffff11 // -239
+	XCursorPseudoEncoding uint32 = 0xffffff10 // -240
+
+	DesktopSizePseudoEncoding uint32 = 0xffffff21 // -223
+)
+
 // buf must contain at least PixelFormatEncodingLength bytes.
 func (pf *PixelFormat) Read(buf []byte, bo binary.ByteOrder) {
 	pf.BitsPerPixel = buf[0]
@@ -124,6 +133,18 @@ func (e *KeyEvent) Read(buf []byte, bo binary.ByteOrder) {
 	e.KeySym = bo.Uint32(buf[3:])
 }
 
+// buf must contain at least KeyEventEncodingLength bytes.
+func (e *KeyEvent) Write(buf []byte, bo binary.ByteOrder) {
+	if e.Pressed {
+		buf[0] = 1
+	} else {
+		buf[0] = 0
+	}
+	buf[1] = 0 // padding
+	buf[2] = 0
+	bo.PutUint32(buf[3:], e.KeySym)
+}
+
 // buf must contain at least PointerEventEncodingLength bytes.
 func (e *PointerEvent) Read(buf []byte, bo binary.ByteOrder) {
 	e.ButtonMask = buf[0]
@@ -131,6 +152,13 @@ func (e *PointerEvent) Read(buf []byte, bo binary.ByteOrder) {
 	e.Y = bo.Uint16(buf[3:])
 }
 
+// buf must contain at least PointerEventEncodingLength bytes.
+func (e *PointerEvent) Write(buf []byte, bo binary.ByteOrder) {
+	buf[0] = e.ButtonMask
+	bo.PutUint16(buf[1:], e.X)
+	bo.PutUint16(buf[3:], e.Y)
+}
+
 func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat) error {
 	var buf [12]byte
 	if _, err := io.ReadFull(r, buf[:]); err != nil {
@@ -141,10 +169,16 @@ func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelF
 	rect.Width = bo.Uint16(buf[4:])
 	rect.Height = bo.Uint16(buf[6:])
 	rect.EncodingType = bo.Uint32(buf[8:])
-	if rect.EncodingType != 0 {
-		return fmt.Errorf("only raw encoding is supported, but it is %d", rect.EncodingType)
+	switch rect.EncodingType {
+	case RawEncoding:
+		rect.PixelData = make([]byte, int(pixelFormat.BitsPerPixel/8)*int(rect.Width)*int(rect.Height))
+	case CursorPseudoEncoding:
+		rect.PixelData = make([]byte, cursorPixelLength(rect, pixelFormat)+cursorMaskLength(rect))
+	case DesktopSizePseudoEncoding:
+		rect.PixelData = nil
+	default:
+		return fmt.Errorf("only raw, cursor, and desktop size encodings are supported, but it is %d", int32(rect.EncodingType))
 	}
-	rect.PixelData = make([]byte, int(pixelFormat.BitsPerPixel/8)*int(rect.Width)*int(rect.Height))
 	if _, err := io.ReadFull(r, rect.PixelData); err != nil {
 		return err
 	}